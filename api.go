@@ -10,6 +10,7 @@ This module implements the Napping API.
 */
 
 import (
+	"context"
 	"net/url"
 )
 
@@ -19,44 +20,92 @@ func Send(r *Request) (*Response, error) {
 	return s.Send(r)
 }
 
+// SendContext composes and sends an HTTP request bound to ctx.
+func SendContext(ctx context.Context, r *Request) (*Response, error) {
+	s := Session{}
+	return s.SendContext(ctx, r)
+}
+
 // Get sends a GET request.
 func Get(url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
 	s := Session{}
 	return s.Get(url, p, result, errMsg)
 }
 
+// GetContext sends a GET request bound to ctx.
+func GetContext(ctx context.Context, url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.GetContext(ctx, url, p, result, errMsg)
+}
+
 // Options sends an OPTIONS request.
 func Options(url string, result, errMsg interface{}) (*Response, error) {
 	s := Session{}
 	return s.Options(url, result, errMsg)
 }
 
+// OptionsContext sends an OPTIONS request bound to ctx.
+func OptionsContext(ctx context.Context, url string, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.OptionsContext(ctx, url, result, errMsg)
+}
+
 // Head sends a HEAD request.
 func Head(url string, result, errMsg interface{}) (*Response, error) {
 	s := Session{}
 	return s.Head(url, result, errMsg)
 }
 
+// HeadContext sends a HEAD request bound to ctx.
+func HeadContext(ctx context.Context, url string, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.HeadContext(ctx, url, result, errMsg)
+}
+
 // Post sends a POST request.
 func Post(url string, payload, result, errMsg interface{}) (*Response, error) {
 	s := Session{}
 	return s.Post(url, payload, result, errMsg)
 }
 
+// PostContext sends a POST request bound to ctx.
+func PostContext(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.PostContext(ctx, url, payload, result, errMsg)
+}
+
 // Put sends a PUT request.
 func Put(url string, payload, result, errMsg interface{}) (*Response, error) {
 	s := Session{}
 	return s.Put(url, payload, result, errMsg)
 }
 
+// PutContext sends a PUT request bound to ctx.
+func PutContext(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.PutContext(ctx, url, payload, result, errMsg)
+}
+
 // Patch sends a PATCH request.
 func Patch(url string, payload, result, errMsg interface{}) (*Response, error) {
 	s := Session{}
 	return s.Patch(url, payload, result, errMsg)
 }
 
+// PatchContext sends a PATCH request bound to ctx.
+func PatchContext(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.PatchContext(ctx, url, payload, result, errMsg)
+}
+
 // Delete sends a DELETE request.
 func Delete(url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
 	s := Session{}
 	return s.Delete(url, p, result, errMsg)
 }
+
+// DeleteContext sends a DELETE request bound to ctx.
+func DeleteContext(ctx context.Context, url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
+	s := Session{}
+	return s.DeleteContext(ctx, url, p, result, errMsg)
+}
@@ -320,7 +320,7 @@ func TestRawRequestInvalidType(t *testing.T) {
 	if err == nil {
 		t.Error("Validation error expected")
 	} else {
-		assert.Equal(t, err.Error(), "Payload must be of type *bytes.Buffer if RawPayload is set to true")
+		assert.Equal(t, err.Error(), "Payload must implement io.Reader if RawPayload is set to true")
 	}
 }
 
@@ -0,0 +1,221 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements pluggable authentication of requests.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Authenticator adds credentials to every request sent, including
+// retries.  It runs before any Middleware.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// A Challenger is an Authenticator that can renew its credentials when the
+// server rejects them with 401 Unauthorized.  If Challenge returns true, the
+// request is authenticated and sent again, once.
+type Challenger interface {
+	Authenticator
+	Challenge(req *http.Request, resp *http.Response) (bool, error)
+}
+
+// authenticated returns a Handler authenticating requests with auth before
+// passing them to next.
+func authenticated(auth Authenticator, next Handler) Handler {
+	return func(req *http.Request) (*Response, error) {
+		if err := auth.Authenticate(req); err != nil {
+			return nil, err
+		}
+		rsp, err := next(req)
+		c, ok := auth.(Challenger)
+		if err != nil || !ok || rsp == nil || rsp.response == nil || rsp.status != http.StatusUnauthorized {
+			return rsp, err
+		}
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return rsp, nil // The request cannot be replayed
+		}
+		replay, err := c.Challenge(req, rsp.response)
+		if err != nil || !replay {
+			return rsp, err
+		}
+		if rsp.Stream {
+			rsp.response.Body.Close()
+		}
+		again := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if again.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if err = auth.Authenticate(again); err != nil {
+			return nil, err
+		}
+		return next(again)
+	}
+}
+
+// authenticator returns the Authenticator for r, if any.
+func (s *Session) authenticator(r *Request) Authenticator {
+	if r.Auth != nil {
+		return r.Auth
+	}
+	return s.Auth
+}
+
+// BearerToken authenticates requests with a static bearer token.
+type BearerToken string
+
+// Authenticate sets the Authorization header of req.
+func (t BearerToken) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+// APIKey authenticates requests with a key sent in a header, or in a query
+// parameter if Query is true.
+type APIKey struct {
+	Name  string // Name of the header or query parameter
+	Value string
+	Query bool
+}
+
+// Authenticate adds the key to req.
+func (k APIKey) Authenticate(req *http.Request) error {
+	if !k.Query {
+		req.Header.Set(k.Name, k.Value)
+		return nil
+	}
+	q := req.URL.Query()
+	q.Set(k.Name, k.Value)
+	req.URL.RawQuery = q.Encode()
+	return nil
+}
+
+// ClientCredentials authenticates requests with OAuth2 access tokens
+// obtained with the client credentials grant (RFC 6749, section 4.4).
+// Tokens are cached, and renewed shortly before they expire or when the
+// server rejects them.  It is safe for concurrent use.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Params       url.Values // Additional parameters of token requests
+
+	// AuthInParams can be set to true to send the client credentials as
+	// parameters of token requests, rather than with HTTP Basic
+	// authentication.
+	AuthInParams bool
+
+	// ExpiryDelta is how long before they expire tokens are renewed.
+	// Defaults to 10 seconds.
+	ExpiryDelta time.Duration
+
+	// Client sends token requests; if nil, http.DefaultClient is used.
+	Client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time // Zero if the token does not expire
+}
+
+// tokenResponse is a successful token response (RFC 6749, section 5.1).
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	TokenType   string      `json:"token_type"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+// tokenError is an error token response (RFC 6749, section 5.2).
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Authenticate sets the Authorization header of req, obtaining a token first
+// if there is no valid one.
+func (c *ClientCredentials) Authenticate(req *http.Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delta := c.ExpiryDelta
+	if delta == 0 {
+		delta = 10 * time.Second
+	}
+	if c.token == "" || (!c.expiry.IsZero() && time.Now().Add(delta).After(c.expiry)) {
+		if err := c.fetch(req); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", c.token)
+	return nil
+}
+
+// Challenge discards the token rejected by the server, unless it has
+// already been renewed.
+func (c *ClientCredentials) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if req.Header.Get("Authorization") == c.token {
+		c.token = ""
+	}
+	return true, nil
+}
+
+// fetch obtains a new token, in the context of req.
+func (c *ClientCredentials) fetch(req *http.Request) error {
+	p := url.Values{"grant_type": {"client_credentials"}}
+	for k, v := range c.Params {
+		p[k] = v
+	}
+	if len(c.Scopes) > 0 {
+		p.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	s := Session{Client: c.Client, Codec: FormCodec{}}
+	if s.Client == nil {
+		s.Client = http.DefaultClient
+	}
+	if c.AuthInParams {
+		p.Set("client_id", c.ClientID)
+		p.Set("client_secret", c.ClientSecret)
+	} else {
+		s.Userinfo = url.UserPassword(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	tok := tokenResponse{}
+	e := tokenError{}
+	resp, err := s.PostContext(req.Context(), c.TokenURL, p, &tok, &e)
+	switch {
+	case err != nil:
+		return err
+	case e.Code != "":
+		return fmt.Errorf("napping: token request failed: %s: %s", e.Code, e.Description)
+	case resp.Status() != http.StatusOK:
+		return fmt.Errorf("napping: token request failed: %d %s", resp.Status(), http.StatusText(resp.Status()))
+	case tok.AccessToken == "":
+		return errors.New("napping: token response has no access_token")
+	}
+	tokenType := tok.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	c.token = tokenType + " " + tok.AccessToken
+	c.expiry = time.Time{}
+	if secs, err := tok.ExpiresIn.Int64(); err == nil && secs > 0 {
+		c.expiry = time.Now().Add(time.Duration(secs) * time.Second)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer hunter2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	s := Session{Auth: BearerToken("hunter2")}
+	resp, err := s.Get(u, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	// Per request
+	r := Request{Url: u, Method: "GET", Auth: BearerToken("wrong")}
+	resp, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, resp.Status())
+}
+
+func TestAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"Foo": 1, "Bar": "%s|%s|%s"}`, req.Header.Get("X-Api-Key"), req.URL.Query().Get("api_key"), req.URL.Query().Get("page"))
+	}))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	res := structType{}
+	s := Session{Auth: APIKey{Name: "X-Api-Key", Value: "hunter2"}}
+	_, err := s.Get(u, nil, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hunter2||", res.Bar)
+	s.Auth = APIKey{Name: "api_key", Value: "hunter2", Query: true}
+	p := Params{"page": "2"}.AsUrlValues()
+	_, err = s.Get(u, &p, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "|hunter2|2", res.Bar)
+}
+
+// oauthServer issues numbered tokens valid for expiresIn seconds, and serves
+// a resource that echoes POST bodies to holders of a valid token.  Tokens
+// can be revoked.
+type oauthServer struct {
+	*httptest.Server
+	expiresIn int
+
+	mu      sync.Mutex
+	issued  int
+	valid   map[string]bool
+	clients []string
+}
+
+func newOAuthServer(expiresIn int) *oauthServer {
+	o := &oauthServer{expiresIn: expiresIn, valid: map[string]bool{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		req.ParseForm()
+		id, secret, ok := req.BasicAuth()
+		if ok {
+			// Basic credentials are form-encoded first (RFC 6749, section 2.3.1)
+			id, _ = url.QueryUnescape(id)
+			secret, _ = url.QueryUnescape(secret)
+		} else {
+			id, secret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.PostForm.Get("grant_type") != "client_credentials" || secret != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "invalid_client", "error_description": "Bad credentials"}`))
+			return
+		}
+		o.issued++
+		token := fmt.Sprintf("token%d", o.issued)
+		o.valid[token] = true
+		o.clients = append(o.clients, id+" "+req.PostForm.Get("scope"))
+		fmt.Fprintf(w, `{"access_token": %q, "token_type": "bearer", "expires_in": %d}`, token, o.expiresIn)
+	})
+	mux.HandleFunc("/resource", flakyHandler(0, 0, new(int32)))
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/resource" {
+			o.mu.Lock()
+			ok := len(req.Header.Get("Authorization")) > 7 && o.valid[req.Header.Get("Authorization")[7:]]
+			o.mu.Unlock()
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		mux.ServeHTTP(w, req)
+	}))
+	return o
+}
+
+// revoke invalidates every token issued.
+func (o *oauthServer) revoke() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.valid = map[string]bool{}
+}
+
+func TestClientCredentials(t *testing.T) {
+	o := newOAuthServer(3600)
+	defer o.Close()
+	u := "http://" + o.Listener.Addr().String()
+	cc := &ClientCredentials{
+		TokenURL:     u + "/token",
+		ClientID:     "napping app",
+		ClientSecret: "s3cr3t",
+		Scopes:       []string{"read", "write"},
+	}
+	s := Session{Auth: cc}
+	res := structType{}
+	for i := 0; i < 3; i++ {
+		resp, err := s.Post(u+"/resource", &fooStruct, &res, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 200, resp.Status())
+		assert.Equal(t, fooStruct, res)
+	}
+	assert.Equal(t, []string{"napping app read write"}, o.clients, "the token is cached")
+	//
+	// A rejected token is renewed, and the request replayed with its body
+	//
+	o.revoke()
+	res = structType{}
+	resp, err := s.Post(u+"/resource", &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, fooStruct, res)
+	assert.Equal(t, 2, o.issued)
+	assert.Equal(t, "Bearer token2", resp.HttpResponse().Request.Header.Get("Authorization"))
+	//
+	// Bad client credentials
+	//
+	cc2 := &ClientCredentials{TokenURL: u + "/token", ClientID: "napping", ClientSecret: "wrong", AuthInParams: true}
+	_, err = Send(&Request{Url: u + "/resource", Method: "GET", Auth: cc2})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "napping: token request failed: invalid_client: Bad credentials", err.Error())
+	}
+}
+
+func TestClientCredentialsExpiry(t *testing.T) {
+	o := newOAuthServer(5)
+	defer o.Close()
+	u := "http://" + o.Listener.Addr().String()
+	s := Session{Auth: &ClientCredentials{
+		TokenURL:     u + "/token",
+		ClientID:     "napping",
+		ClientSecret: "s3cr3t",
+		AuthInParams: true,
+	}}
+	//
+	// Tokens expiring within ExpiryDelta are renewed before being used
+	//
+	for i := 0; i < 2; i++ {
+		resp, err := s.Get(u+"/resource", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 200, resp.Status())
+	}
+	assert.Equal(t, 2, o.issued)
+	assert.Equal(t, []string{"napping ", "napping "}, o.clients)
+}
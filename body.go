@@ -0,0 +1,157 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements request bodies that can be sent more than once.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// ErrBodyNotReplayable is returned when a request body that has already been
+// sent is needed again, e.g. to retry the request, but can be neither rewound
+// nor recreated.
+var ErrBodyNotReplayable = errors.New("napping: request body cannot be replayed")
+
+// A body produces a fresh reader over a request payload for every attempt to
+// send it.
+type body struct {
+	data    []byte                        // Encoded or buffered payload
+	reader  io.Reader                     // Raw payload, read by the first attempt
+	getBody func() (io.ReadCloser, error) // Recreates the raw payload
+	length  int64                         // Length of the payload; -1 if unknown
+	offset  int64                         // Start of the payload, if reader is an io.Seeker
+	once    bool                          // Whether getBody can only be called once
+	used    bool                          // Whether reader or getBody has been used
+}
+
+// newBody returns a body for the payload of r, which has already been
+// encoded to data unless r.RawPayload is set.
+func newBody(r *Request, data []byte) (*body, error) {
+	if !r.RawPayload {
+		if data == nil {
+			return nil, nil
+		}
+		return &body{data: data, length: int64(len(data))}, nil
+	}
+	b := &body{
+		getBody: r.GetBody,
+		length:  -1,
+	}
+	if r.ContentLength > 0 {
+		b.length = r.ContentLength
+	}
+	if r.Payload != nil {
+		// Payload can be a typed nil at this point, which is the same
+		// as no payload at all.
+		v := reflect.ValueOf(r.Payload)
+		if v.Kind() != reflect.Ptr || !v.IsNil() {
+			var ok bool
+			b.reader, ok = r.Payload.(io.Reader)
+			if !ok {
+				return nil, errors.New("Payload must implement io.Reader if RawPayload is set to true")
+			}
+		}
+	}
+	switch rd := b.reader.(type) {
+	case nil:
+		if b.getBody == nil {
+			return nil, nil
+		}
+	case *bytes.Buffer:
+		// Buffers are replayed from their contents, as before io.Reader
+		// payloads were supported.  Empty buffers give an empty, not a
+		// nil, slice.
+		b.data = append([]byte{}, rd.Bytes()...)
+		b.reader = nil
+		b.length = int64(len(b.data))
+	case io.Seeker:
+		var err error
+		b.offset, err = rd.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if b.length < 0 {
+			end, err := rd.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, err
+			}
+			b.length = end - b.offset
+			if _, err = rd.Seek(b.offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// replayable reports whether the body can be opened again once it has been
+// sent.
+func (b *body) replayable() bool {
+	if b != nil && b.once {
+		return false
+	}
+	if b == nil || b.data != nil || b.getBody != nil {
+		return true
+	}
+	_, ok := b.reader.(io.Seeker)
+	return ok
+}
+
+// open returns a reader over the whole payload.
+func (b *body) open() (io.ReadCloser, error) {
+	switch {
+	case b.data != nil:
+		return ioutil.NopCloser(bytes.NewReader(b.data)), nil
+	case b.reader != nil && !b.used:
+		b.used = true
+		return ioutil.NopCloser(b.reader), nil
+	case b.getBody != nil:
+		if b.once && b.used {
+			return nil, ErrBodyNotReplayable
+		}
+		b.used = true
+		return b.getBody()
+	}
+	if s, ok := b.reader.(io.Seeker); ok {
+		if _, err := s.Seek(b.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(b.reader), nil
+	}
+	return nil, ErrBodyNotReplayable
+}
+
+// copier returns a function creating independent copies of the payload,
+// for http.Request.GetBody, or nil if none can be made without disturbing
+// the reader of the body being sent.
+func (b *body) copier() func() (io.ReadCloser, error) {
+	switch {
+	case b.data != nil:
+		return func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(b.data)), nil
+		}
+	case b.getBody != nil:
+		if b.once {
+			return nil
+		}
+		return b.getBody
+	}
+	_, seeker := b.reader.(io.Seeker)
+	ra, ok := b.reader.(io.ReaderAt)
+	if !seeker || !ok || b.length < 0 {
+		return nil
+	}
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(ra, b.offset, b.length)), nil
+	}
+}
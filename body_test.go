@@ -0,0 +1,275 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lengthEcho describes the Content-Length, encoding and body of a request.
+type lengthEcho struct {
+	Length  int64
+	Chunked bool
+	Body    string
+}
+
+// handleLengthEcho answers with a lengthEcho describing the request.
+func handleLengthEcho(w http.ResponseWriter, req *http.Request) {
+	b, _ := ioutil.ReadAll(req.Body)
+	res := lengthEcho{
+		Length:  req.ContentLength,
+		Chunked: len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked",
+		Body:    string(b),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	blob, _ := JSONCodec{}.Marshal(res)
+	w.Write(blob)
+}
+
+func TestReaderPayload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleLengthEcho))
+	defer srv.Close()
+	url := "http://" + srv.Listener.Addr().String()
+	//
+	// Unknown length is sent chunked
+	//
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("nap"))
+		pw.Write([]byte("ping"))
+		pw.Close()
+	}()
+	res := lengthEcho{}
+	r := Request{
+		Url:        url,
+		Method:     "PUT",
+		Payload:    pr,
+		RawPayload: true,
+		Result:     &res,
+	}
+	_, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lengthEcho{-1, true, "napping"}, res)
+	//
+	// Known length is sent as Content-Length
+	//
+	pr, pw = io.Pipe()
+	go func() {
+		pw.Write([]byte("napping"))
+		pw.Close()
+	}()
+	r = Request{
+		Url:           url,
+		Method:        "PUT",
+		Payload:       pr,
+		RawPayload:    true,
+		ContentLength: 7,
+		Result:        &res,
+	}
+	_, err = Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lengthEcho{7, false, "napping"}, res)
+	//
+	// Seekers are measured from their current offset
+	//
+	sr := strings.NewReader("xxnapping")
+	sr.Seek(2, io.SeekStart)
+	r = Request{
+		Url:        url,
+		Method:     "PUT",
+		Payload:    sr,
+		RawPayload: true,
+		Result:     &res,
+	}
+	_, err = Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lengthEcho{7, false, "napping"}, res)
+	//
+	// Empty buffers are sent as empty bodies
+	//
+	res = lengthEcho{}
+	r = Request{
+		Url:        url,
+		Method:     "POST",
+		Payload:    new(bytes.Buffer),
+		RawPayload: true,
+		Result:     &res,
+	}
+	_, err = Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lengthEcho{0, false, ""}, res)
+}
+
+// replayHandler fails the first request with a 503, then echoes the length
+// and body of the second.
+func replayHandler(calls *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(calls, 1) == 1 {
+			ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handleLengthEcho(w, req)
+	}
+}
+
+func TestReaderPayloadReplay(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(replayHandler(&calls))
+	defer srv.Close()
+	url := "http://" + srv.Listener.Addr().String()
+	policy := fastRetry
+	s := Session{Retry: &policy}
+	//
+	// Seekers are rewound
+	//
+	res := lengthEcho{}
+	r := Request{
+		Url:        url,
+		Method:     "PUT",
+		Payload:    strings.NewReader("napping"),
+		RawPayload: true,
+		Result:     &res,
+	}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, resp.Attempts())
+	assert.Equal(t, lengthEcho{7, false, "napping"}, res)
+	//
+	// Other readers are recreated with GetBody
+	//
+	atomic.StoreInt32(&calls, 0)
+	opened := 0
+	r = Request{
+		Url:        url,
+		Method:     "PUT",
+		RawPayload: true,
+		GetBody: func() (io.ReadCloser, error) {
+			opened++
+			return ioutil.NopCloser(io.LimitReader(strings.NewReader("nappingxx"), 7)), nil
+		},
+		Result: &res,
+	}
+	resp, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, resp.Attempts())
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, lengthEcho{-1, true, "napping"}, res)
+	//
+	// Readers that can't be replayed aren't retried
+	//
+	atomic.StoreInt32(&calls, 0)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("napping"))
+		pw.Close()
+	}()
+	r = Request{
+		Url:        url,
+		Method:     "PUT",
+		Payload:    pr,
+		RawPayload: true,
+	}
+	resp, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 503, resp.Status())
+	assert.Equal(t, 1, resp.Attempts())
+}
+
+func TestReaderPayloadRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/new", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/new", handleLengthEcho)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	res := lengthEcho{}
+	r := Request{
+		Url:        "http://" + srv.Listener.Addr().String() + "/old",
+		Method:     "POST",
+		Payload:    strings.NewReader("napping"),
+		RawPayload: true,
+		Result:     &res,
+	}
+	_, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lengthEcho{7, false, "napping"}, res)
+}
+
+func TestReaderPayloadGetBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleLengthEcho))
+	defer srv.Close()
+	url := "http://" + srv.Listener.Addr().String()
+	//
+	// HAR and signers read copies of the body before it is sent
+	//
+	sessions := map[string]*Session{
+		"har":    {HAR: &HARRecorder{}},
+		"signer": {Signer: &HMACSigner{KeyID: "key1", Secret: []byte("hunter2")}},
+		"sigv4":  {Signer: &SigV4{AccessKeyID: "a", SecretAccessKey: "b", Region: "r", Service: "s"}},
+	}
+	for name, s := range sessions {
+		res := lengthEcho{}
+		r := Request{
+			Url:        url,
+			Method:     "PUT",
+			Payload:    strings.NewReader("hello"),
+			RawPayload: true,
+			Result:     &res,
+		}
+		_, err := s.Send(&r)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		assert.Equal(t, lengthEcho{Length: 5, Body: "hello"}, res, name)
+	}
+	//
+	// Copies are independent of the body, and start where it did
+	//
+	rd := strings.NewReader("xxhello")
+	rd.Seek(2, io.SeekStart)
+	b, err := newBody(&Request{Payload: rd, RawPayload: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := b.open()
+	dup, _ := b.copier()()
+	p, _ := ioutil.ReadAll(dup)
+	assert.Equal(t, "hello", string(p))
+	p, _ = ioutil.ReadAll(body)
+	assert.Equal(t, "hello", string(p))
+	//
+	// Readers that cannot be copied have no GetBody
+	//
+	b, _ = newBody(&Request{Payload: struct{ io.ReadSeeker }{rd}, RawPayload: true}, nil)
+	assert.Nil(t, b.copier())
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements a transport that records HTTP interactions to a
+cassette file and replays them, so tests can run without the network.
+*/
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrNoInteraction is returned by a Cassette in CassetteReplay mode for a
+// request that matches no recorded interaction.
+var ErrNoInteraction = errors.New("napping: no matching interaction in cassette")
+
+// A CassetteMode decides whether a Cassette replays or records interactions.
+type CassetteMode int
+
+const (
+	// CassetteAuto replays matching interactions and records the others.
+	CassetteAuto CassetteMode = iota
+
+	// CassetteRecord records every interaction, replacing the cassette.
+	CassetteRecord
+
+	// CassetteReplay is the strict mode: it only replays, and fails
+	// requests that match no interaction with ErrNoInteraction.
+	CassetteReplay
+)
+
+// A CassetteMatch selects the parts of a request compared to find the
+// matching interaction.
+type CassetteMatch int
+
+const (
+	MatchMethod CassetteMatch = 1 << iota // HTTP method
+	MatchURL                              // Scheme, host and path
+	MatchParams                           // Query parameters, in any order
+	MatchBody                             // Request body
+
+	DefaultCassetteMatch = MatchMethod | MatchURL | MatchParams
+)
+
+// A Cassette is an http.RoundTripper that records interactions and replays
+// them.  Use it as the Transport of Session.Client:
+//
+//	c, err := napping.OpenCassette("testdata/api.json", napping.CassetteAuto)
+//	...
+//	defer c.Save()
+//	s := napping.Session{Client: &http.Client{Transport: c}}
+//
+// Matching interactions are replayed in the order they were recorded.  Once
+// they are exhausted, further requests are recorded, or in CassetteReplay
+// mode the last one is replayed again.
+type Cassette struct {
+	Path string // File the cassette is loaded from and saved to
+	Mode CassetteMode
+
+	// Match selects the parts of requests that are compared; if zero,
+	// DefaultCassetteMatch is used.
+	Match CassetteMatch
+
+	// Redaction scrubs secrets from interactions before they are recorded;
+	// requests are scrubbed the same way before being matched.  If nil,
+	// DefaultRedaction is used.
+	Redaction *Redaction
+
+	// Transport sends the requests that are recorded; if nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []*interaction
+	played       map[*interaction]bool
+	changed      bool
+}
+
+// OpenCassette returns a Cassette for the file at path, loading its
+// interactions unless mode is CassetteRecord.  The file need not exist.
+func OpenCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{Path: path, Mode: mode}
+	if mode == CassetteRecord {
+		c.changed = true // Even an empty recording replaces the file
+		return c, nil
+	}
+	blob, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if mode == CassetteReplay {
+			return nil, err
+		}
+		return c, nil
+	case err != nil:
+		return nil, err
+	}
+	doc := cassetteFile{}
+	if err = json.Unmarshal(blob, &doc); err != nil {
+		return nil, fmt.Errorf("napping: bad cassette %s: %v", path, err)
+	}
+	c.interactions = doc.Interactions
+	return c, nil
+}
+
+// Len returns the number of interactions in the cassette.
+func (c *Cassette) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.interactions)
+}
+
+// Save writes the cassette to its Path, if interactions were recorded since
+// it was opened.
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	doc := cassetteFile{Interactions: c.interactions}
+	if doc.Interactions == nil {
+		doc.Interactions = []*interaction{}
+	}
+	blob, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(c.Path, append(blob, '\n'), 0644); err != nil {
+		return err
+	}
+	c.changed = false
+	return nil
+}
+
+// RoundTrip replays the interaction matching req, or sends and records it,
+// according to the Mode.
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	rd := c.redaction()
+	scrubbed := rd.recordRequest(req, body)
+	if c.Mode != CassetteRecord {
+		next, last := c.find(scrubbed)
+		if next == nil && c.Mode == CassetteReplay {
+			next = last
+		}
+		if next != nil {
+			return next.Response.response(req)
+		}
+		if c.Mode == CassetteReplay {
+			return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, scrubbed.URL)
+		}
+	}
+	//
+	// Record
+	//
+	out := req.Clone(req.Context())
+	if req.Body != nil {
+		out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	t := c.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	resp, err := t.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	i := &interaction{
+		Request:    scrubbed,
+		Response:   rd.recordResponse(resp, respBody),
+		RecordedAt: time.Now().UTC(),
+	}
+	c.mu.Lock()
+	c.interactions = append(c.interactions, i)
+	c.markPlayed(i)
+	c.changed = true
+	c.mu.Unlock()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// redaction returns the Cassette's Redaction, or DefaultRedaction.
+func (c *Cassette) redaction() *Redaction {
+	if c.Redaction != nil {
+		return c.Redaction
+	}
+	return &DefaultRedaction
+}
+
+// find returns the first interaction matching req that was not played yet,
+// marking it played, and the last matching interaction that was.
+func (c *Cassette) find(req *recordedRequest) (next, last *interaction) {
+	match := c.Match
+	if match == 0 {
+		match = DefaultCassetteMatch
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, i := range c.interactions {
+		if !i.Request.matches(req, match) {
+			continue
+		}
+		if !c.played[i] {
+			c.markPlayed(i)
+			return i, last
+		}
+		last = i
+	}
+	return nil, last
+}
+
+// markPlayed records that i was played, so it is not replayed again.
+func (c *Cassette) markPlayed(i *interaction) {
+	if c.played == nil {
+		c.played = map[*interaction]bool{}
+	}
+	c.played[i] = true
+}
+
+//
+// Cassette file
+//
+
+type cassetteFile struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+type interaction struct {
+	Request    *recordedRequest  `json:"request"`
+	Response   *recordedResponse `json:"response"`
+	RecordedAt time.Time         `json:"recorded_at"`
+}
+
+type recordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	recordedBody
+}
+
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	recordedBody
+}
+
+// A recordedBody holds text bodies as they are, and binary bodies encoded
+// with base64.
+type recordedBody struct {
+	Body     string `json:"body"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// recordRequest returns the scrubbed recording of req, whose body has been
+// read.
+func (rd *Redaction) recordRequest(req *http.Request, body []byte) *recordedRequest {
+	u := *req.URL
+	u.User = nil
+	return &recordedRequest{
+		Method:       req.Method,
+		URL:          rd.url(&u),
+		Header:       rd.header(req.Header),
+		recordedBody: rd.recordBody(body, req.Header.Get("Content-Type")),
+	}
+}
+
+// recordResponse returns the scrubbed recording of resp, whose body has
+// been read.
+func (rd *Redaction) recordResponse(resp *http.Response, body []byte) *recordedResponse {
+	return &recordedResponse{
+		Status:       resp.StatusCode,
+		Header:       rd.header(resp.Header),
+		recordedBody: rd.recordBody(body, resp.Header.Get("Content-Type")),
+	}
+}
+
+// recordBody returns the scrubbed recording of a body of the given
+// Content-Type.
+func (rd *Redaction) recordBody(body []byte, contentType string) recordedBody {
+	switch {
+	case len(body) == 0:
+		return recordedBody{}
+	case !utf8.Valid(body):
+		return recordedBody{Body: base64.StdEncoding.EncodeToString(body), Encoding: "base64"}
+	}
+	return recordedBody{Body: rd.payload(body, contentType)}
+}
+
+// bytes returns the recorded body.
+func (b recordedBody) bytes() ([]byte, error) {
+	if b.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(b.Body)
+	}
+	return []byte(b.Body), nil
+}
+
+// matches reports whether the parts of r selected by match are those of
+// other.
+func (r *recordedRequest) matches(other *recordedRequest, match CassetteMatch) bool {
+	if match&MatchMethod != 0 && r.Method != other.Method {
+		return false
+	}
+	if match&MatchBody != 0 && r.recordedBody != other.recordedBody {
+		return false
+	}
+	if match&(MatchURL|MatchParams) == 0 {
+		return true
+	}
+	u1, err1 := url.Parse(r.URL)
+	u2, err2 := url.Parse(other.URL)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	if match&MatchURL != 0 && (u1.Scheme != u2.Scheme || u1.Host != u2.Host || u1.Path != u2.Path) {
+		return false
+	}
+	if match&MatchParams != 0 && !reflect.DeepEqual(u1.Query(), u2.Query()) {
+		return false
+	}
+	return true
+}
+
+// response returns the recorded response to req.
+func (r *recordedResponse) response(req *http.Request) (*http.Response, error) {
+	body, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	// Scrubbing can change the length of the body
+	header.Set("Content-Length", fmt.Sprint(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingServer answers every request with the number of requests it has
+// received, and the request body.
+func countingServer() *httptest.Server {
+	var calls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Foo": %d, "Bar": %q}`, n, body)
+	}))
+}
+
+func TestCassette(t *testing.T) {
+	srv := countingServer()
+	u := "http://" + srv.Listener.Addr().String()
+	path := filepath.Join(t.TempDir(), "cassettes", "api.json")
+	//
+	// Record
+	//
+	c, err := OpenCassette(path, CassetteAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Session{Client: &http.Client{Transport: c}}
+	res := structType{}
+	for i := 1; i <= 2; i++ {
+		_, err = s.Get(u+"/a", nil, &res, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, i, res.Foo)
+	}
+	_, err = s.Post(u+"/b", &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, c.Len())
+	if err = c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	//
+	// Replay, without the server
+	//
+	c, err = OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = Session{Client: &http.Client{Transport: c}}
+	for _, expected := range []int{1, 2, 2} {
+		resp, err := s.Get(u+"/a", nil, &res, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 200, resp.Status())
+		assert.Equal(t, expected, res.Foo)
+	}
+	_, err = s.Post(u+"/b", &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, structType{3, `{"Foo":111,"Bar":"foo"}`}, res)
+	//
+	// Strict mode
+	//
+	_, err = s.Get(u+"/c", nil, &res, nil)
+	assert.True(t, errors.Is(err, ErrNoInteraction), err)
+	p := Params{"page": "2"}.AsUrlValues()
+	_, err = s.Get(u+"/a", &p, &res, nil)
+	assert.True(t, errors.Is(err, ErrNoInteraction), err)
+	_, err = OpenCassette(filepath.Join(t.TempDir(), "missing.json"), CassetteReplay)
+	assert.NotNil(t, err)
+}
+
+func TestCassetteMatchBody(t *testing.T) {
+	srv := countingServer()
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	c := &Cassette{Match: DefaultCassetteMatch | MatchBody}
+	s := Session{Client: &http.Client{Transport: c}}
+	res := structType{}
+	s.Post(u, &fooStruct, &res, nil)
+	s.Post(u, &barStruct, &res, nil)
+	assert.Equal(t, 2, c.Len())
+	c.Mode = CassetteReplay
+	_, err := s.Post(u, &barStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, res.Foo)
+	_, err = s.Post(u, &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, res.Foo)
+	_, err = s.Post(u, &structType{}, &res, nil)
+	assert.True(t, errors.Is(err, ErrNoInteraction), err)
+	//
+	// Record mode always sends
+	//
+	c.Mode = CassetteRecord
+	s.Post(u, &fooStruct, &res, nil)
+	assert.Equal(t, 3, res.Foo)
+}
+
+func TestCassetteScrubbing(t *testing.T) {
+	srv := countingServer()
+	u := "http://" + srv.Listener.Addr().String()
+	path := filepath.Join(t.TempDir(), "api.json")
+	rd := DefaultRedaction
+	rd.Params = []string{"api_key"}
+	rd.Fields = []string{"Bar"}
+	c, err := OpenCassette(path, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Redaction = &rd
+	p := Params{"api_key": "hunter2"}.AsUrlValues()
+	s := Session{
+		Client: &http.Client{Transport: c},
+		Header: &http.Header{"Authorization": {"Bearer hunter2"}},
+		Params: &p,
+	}
+	res := structType{}
+	_, err = s.Post(u, &structType{1, "hunter2"}, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `{"Foo":1,"Bar":"hunter2"}`, res.Bar)
+	if err = c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(blob), "hunter2")
+	//
+	// Scrubbed requests still match
+	//
+	c, err = OpenCassette(path, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Redaction = &rd
+	s.Client = &http.Client{Transport: c}
+	_, err = s.Post(u, &structType{1, "hunter2"}, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, res.Foo)
+	assert.Equal(t, Redacted, res.Bar)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module chooses and configures the http.Client used to send a request.
+
+Every request gets its own http.Client, which costs nothing: connection pools
+belong to transports.  The transports derived from a base transport, the
+Session's proxy and TLS settings, and the timeouts of the request are cached
+by the Session, so requests with the same settings share their connections.
+Only the most recently used are kept, so that a Request.Transport created for
+each request does not make the cache grow forever.
+*/
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// maxTransports is the number of derived transports cached by a Session.
+const maxTransports = 16
+
+// transportKey identifies the settings a transport was derived with.
+type transportKey struct {
+	base     *http.Transport // Nil for http.DefaultTransport
+	proxy    *Proxy
+	tls      *TLS
+	timeouts Timeouts // Those applied by the transport
+}
+
+// cachedTransport is a derived transport cached by a Session.
+type cachedTransport struct {
+	*http.Transport
+	used uint64 // When last returned, counted in calls to Session.transport
+}
+
+// client returns the http.Client used to send r.  The Session's Client is
+// copied, never changed, so the settings of r apply to r alone.
+func (s *Session) client(r *Request) (*http.Client, error) {
+	var client http.Client
+	if s.Client != nil {
+		client = *s.Client
+	} else {
+		client.Jar = s.jar()
+	}
+	//
+	// Transport; that of a Client set by the caller is used unchanged,
+	// unless the request has its own
+	//
+	if s.Client == nil || r.Transport != nil {
+		t, err := s.transport(r.Transport, s.timeouts(r).transport())
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			client.Transport = t
+		}
+	}
+	//
+	// Redirect policy
+	//
+	redirect := s.Redirect
+	if r.Redirect != nil {
+		redirect = r.Redirect
+	}
+	if redirect != nil {
+		client.CheckRedirect = redirect.check
+	}
+	return &client, nil
+}
+
+// jar returns the Session's cookie jar, creating it if needed.
+func (s *Session) jar() *CookieJar {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Jar == nil {
+		s.Jar = &CookieJar{}
+	}
+	return s.Jar
+}
+
+// transport returns base with the Session's proxy and TLS settings and
+// timeouts applied, or nil if the default transport is used as is.
+func (s *Session) transport(base *http.Transport, timeouts Timeouts) (*http.Transport, error) {
+	if s.Proxy == nil && s.TLS == nil && timeouts == (Timeouts{}) {
+		return base, nil
+	}
+	key := transportKey{base: base, proxy: s.Proxy, tls: s.TLS, timeouts: timeouts}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uses++
+	if t, ok := s.transports[key]; ok {
+		t.used = s.uses
+		return t.Transport, nil
+	}
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := base.Clone()
+	if s.Proxy != nil {
+		t.Proxy = s.Proxy.proxy
+	}
+	if s.TLS != nil {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		if err := s.TLS.apply(t.TLSClientConfig); err != nil {
+			return nil, err
+		}
+	}
+	timeouts.apply(t)
+	if s.transports == nil {
+		s.transports = map[transportKey]*cachedTransport{}
+	}
+	if len(s.transports) >= maxTransports {
+		s.evictTransport()
+	}
+	s.transports[key] = &cachedTransport{Transport: t, used: s.uses}
+	return t, nil
+}
+
+// evictTransport drops the least recently used transport from the cache,
+// and closes its idle connections.  Requests still using it are unaffected.
+func (s *Session) evictTransport() {
+	var oldest transportKey
+	var t *cachedTransport
+	for k, c := range s.transports {
+		if t == nil || c.used < t.used {
+			oldest, t = k, c
+		}
+	}
+	if t != nil {
+		delete(s.transports, oldest)
+		t.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dialLog records the names of the transports dialing connections.
+type dialLog struct {
+	mu    sync.Mutex
+	names []string
+}
+
+// transport returns a new transport recorded as name.
+func (d *dialLog) transport(name string) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d.mu.Lock()
+			d.names = append(d.names, name)
+			d.mu.Unlock()
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// closeLog counts the connections closed by transports.
+type closeLog struct {
+	net.Conn
+	closed *int32
+}
+
+func (c closeLog) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return c.Conn.Close()
+}
+
+func TestRequestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	defer srv.Close()
+	d := &dialLog{}
+	a, b := d.transport("a"), d.transport("b")
+	send := func(s *Session, transport *http.Transport) {
+		r := Request{Url: srv.URL, Method: "GET", Transport: transport}
+		if _, err := s.Send(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//
+	// Each request uses its own transport, and the Session's Client is
+	// left alone
+	//
+	client := &http.Client{Transport: d.transport("client")}
+	s := Session{Client: client}
+	send(&s, a)
+	send(&s, b)
+	send(&s, nil)
+	send(&s, a)
+	assert.Equal(t, []string{"a", "b", "client"}, d.names)
+	assert.True(t, s.Client == client)
+	assert.Nil(t, s.Jar, "the Client has no jar")
+	//
+	// Transports derived with the same settings are shared
+	//
+	d.names = nil
+	s = Session{Proxy: &Proxy{}}
+	send(&s, a)
+	send(&s, b)
+	send(&s, a)
+	send(&s, b)
+	assert.Equal(t, []string{"a", "b"}, d.names)
+	assert.Len(t, s.transports, 2)
+	s.Proxy = &Proxy{NoProxy: []string{"*"}}
+	send(&s, a)
+	assert.Equal(t, []string{"a", "b", "a"}, d.names)
+	assert.Len(t, s.transports, 3)
+}
+
+func TestTransportCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleEmptyOK))
+	defer srv.Close()
+	var closed int32
+	fresh := func() *http.Transport {
+		return &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return closeLog{c, &closed}, nil
+			},
+		}
+	}
+	s := Session{Proxy: &Proxy{}}
+	send := func(transport *http.Transport) {
+		r := Request{Url: srv.URL, Method: "GET", Transport: transport}
+		if _, err := s.Send(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//
+	// A fresh transport for every request does not grow the cache, and the
+	// idle connections of evicted transports are closed
+	//
+	kept := fresh()
+	send(kept)
+	for i := 0; i < maxTransports+4; i++ {
+		send(fresh())
+		send(kept)
+	}
+	assert.Len(t, s.transports, maxTransports)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&closed))
+	assert.Contains(t, s.transports, transportKey{base: kept, proxy: s.Proxy})
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the codecs used to encode payloads and decode
+responses.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+)
+
+// A Codec encodes request payloads and decodes response bodies in one
+// particular format.
+type Codec interface {
+	// ContentType returns the media type of payloads encoded by the Codec,
+	// e.g. "application/json".  Responses are decoded with the Codec whose
+	// ContentType matches their own.
+	ContentType() string
+
+	// Accept returns the Accept header to send with requests whose payload
+	// is encoded by the Codec, or "" if the Codec does not determine the
+	// format of responses.
+	Accept() string
+
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// A Decoder reads and decodes successive values from a stream.
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// A StreamCodec is a Codec that can decode values incrementally from a
+// stream, rather than from a fully buffered body.
+type StreamCodec interface {
+	Codec
+	NewDecoder(r io.Reader) Decoder
+}
+
+// JSONCodec encodes and decodes JSON.  It is the default Codec.
+type JSONCodec struct{}
+
+// ContentType returns "application/json".
+func (JSONCodec) ContentType() string { return "application/json" }
+
+// Accept returns "application/json".
+func (JSONCodec) Accept() string { return "application/json" }
+
+// Marshal returns the JSON encoding of v.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal parses JSON-encoded data into v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// NewDecoder returns a Decoder reading successive JSON values from r.
+func (JSONCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
+
+// XMLCodec encodes and decodes XML.
+type XMLCodec struct{}
+
+// ContentType returns "application/xml".
+func (XMLCodec) ContentType() string { return "application/xml" }
+
+// Accept returns "application/xml".
+func (XMLCodec) Accept() string { return "application/xml" }
+
+// Marshal returns the XML encoding of v.
+func (XMLCodec) Marshal(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+// Unmarshal parses XML-encoded data into v.
+func (XMLCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// NewDecoder returns a Decoder reading successive XML elements from r.
+func (XMLCodec) NewDecoder(r io.Reader) Decoder { return xml.NewDecoder(r) }
+
+// bufferedDecoder adapts a Codec that cannot decode incrementally to the
+// Decoder interface.  The whole stream is read on the first call to Decode,
+// and later calls return io.EOF.
+type bufferedDecoder struct {
+	codec Codec
+	r     io.Reader
+}
+
+func (d *bufferedDecoder) Decode(v interface{}) error {
+	if d.r == nil {
+		return io.EOF
+	}
+	data, err := ioutil.ReadAll(d.r)
+	d.r = nil
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return io.EOF
+	}
+	return d.codec.Unmarshal(data, v)
+}
+
+// newDecoder returns a Decoder reading from r with codec, incrementally if
+// codec supports it.
+func newDecoder(codec Codec, r io.Reader) Decoder {
+	if sc, ok := codec.(StreamCodec); ok {
+		return sc.NewDecoder(r)
+	}
+	return &bufferedDecoder{codec: codec, r: r}
+}
+
+// A StatusRange is an inclusive range of HTTP statuses.
+type StatusRange struct {
+	Min, Max int
+}
+
+// A DecodePolicy decides, based on its status, whether a response is decoded
+// into the Request's Result, its Error, or neither.  Responses that are left
+// undecoded are still available from Response.RawText and
+// Response.Unmarshal.
+type DecodePolicy struct {
+	Result []StatusRange // Statuses decoded into Result
+	Error  []StatusRange // Statuses decoded into Error
+}
+
+// DefaultDecodePolicy decodes successful responses into Result, and client
+// and server errors into Error.  Redirects and other 3xx responses are left
+// undecoded.
+var DefaultDecodePolicy = DecodePolicy{
+	Result: []StatusRange{{100, 299}},
+	Error:  []StatusRange{{400, 999}},
+}
+
+func inRanges(status int, ranges []StatusRange) bool {
+	for _, sr := range ranges {
+		if status >= sr.Min && status <= sr.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// isResult reports whether responses with status are decoded into Result.
+func (p *DecodePolicy) isResult(status int) bool {
+	return inRanges(status, p.Result)
+}
+
+// isError reports whether responses with status are decoded into Error.
+func (p *DecodePolicy) isError(status int) bool {
+	return inRanges(status, p.Error)
+}
+
+// decodePolicy returns the DecodePolicy for r.
+func (s *Session) decodePolicy(r *Request) *DecodePolicy {
+	if r.DecodePolicy != nil {
+		return r.DecodePolicy
+	}
+	if s.DecodePolicy != nil {
+		return s.DecodePolicy
+	}
+	return &DefaultDecodePolicy
+}
+
+// builtinCodecs are consulted after any codecs configured on the Session or
+// Request when choosing how to decode a response.
+var builtinCodecs = []Codec{JSONCodec{}, XMLCodec{}}
+
+// encoder returns the Codec used to encode the payload of r.
+func (s *Session) encoder(r *Request) Codec {
+	if r.Codec != nil {
+		return r.Codec
+	}
+	return s.defaultCodec()
+}
+
+// defaultCodec returns the Session's Codec, or JSON if none is set.
+func (s *Session) defaultCodec() Codec {
+	if s.Codec != nil {
+		return s.Codec
+	}
+	return JSONCodec{}
+}
+
+// decoder returns the Codec used to decode a response to r with the given
+// Content-Type header.  If no known Codec handles contentType, the
+// responseCodec is used.
+func (s *Session) decoder(r *Request, contentType string) Codec {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		candidates := []Codec{}
+		if r.Codec != nil {
+			candidates = append(candidates, r.Codec)
+		}
+		if s.Codec != nil {
+			candidates = append(candidates, s.Codec)
+		}
+		candidates = append(candidates, s.Codecs...)
+		candidates = append(candidates, builtinCodecs...)
+		for _, c := range candidates {
+			if mediaTypeMatches(c.ContentType(), mt) {
+				return c
+			}
+		}
+	}
+	return s.responseCodec()
+}
+
+// responseCodec returns the Codec for responses in an unknown format, which
+// is the Session's Codec unless that does not determine the format of
+// responses, and JSON otherwise.
+func (s *Session) responseCodec() Codec {
+	if s.Codec != nil && s.Codec.Accept() != "" {
+		return s.Codec
+	}
+	return JSONCodec{}
+}
+
+// mediaTypeMatches reports whether a response of media type mt can be decoded
+// by a Codec whose content type is ct.  Besides exact matches, structured
+// syntax suffixes ("application/problem+json") and text/ variants
+// ("text/xml") of the codec's subtype are accepted.
+func mediaTypeMatches(ct, mt string) bool {
+	if ct == mt {
+		return true
+	}
+	i := strings.Index(ct, "/")
+	if i < 0 {
+		return false
+	}
+	sub := ct[i+1:]
+	return strings.HasSuffix(mt, "+"+sub) || mt == "text/"+sub
+}
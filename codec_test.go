@@ -0,0 +1,232 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// upperCodec is a toy codec for "text/x-upper" bodies, which hold a single
+// upper-cased string.
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string { return "text/x-upper" }
+func (upperCodec) Accept() string      { return "text/x-upper" }
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.New("upperCodec can only marshal strings")
+	}
+	return []byte(strings.ToUpper(s)), nil
+}
+
+func (upperCodec) Unmarshal(data []byte, v interface{}) error {
+	p, ok := v.(*string)
+	if !ok {
+		return errors.New("upperCodec can only unmarshal into *string")
+	}
+	*p = string(data)
+	return nil
+}
+
+// HandleXML expects an XML-encoded fooStruct and answers with an XML-encoded
+// barStruct.
+func HandleXML(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/xml" {
+		http.Error(w, "Bad content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	var s structType
+	body, _ := ioutil.ReadAll(req.Body)
+	if err := xml.Unmarshal(body, &s); err != nil || s != fooStruct {
+		w.Header().Set("Content-Type", "application/problem+xml")
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(errorStruct{Status: 400, Message: "Bad request body"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	xml.NewEncoder(w).Encode(barStruct)
+}
+
+func TestXMLCodec(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleXML))
+	defer srv.Close()
+	url := "http://" + srv.Listener.Addr().String()
+	s := Session{Codec: XMLCodec{}}
+	res := structType{}
+	resp, err := s.Post(url, &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, barStruct, res)
+	assert.Equal(t, "application/xml", resp.HttpResponse().Request.Header.Get("Accept"))
+	//
+	// Error bodies are decoded with the codec matching their Content-Type
+	//
+	e := errorStruct{}
+	resp, err = s.Post(url, &barStruct, nil, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 400, resp.Status())
+	assert.Equal(t, "Bad request body", e.Message)
+}
+
+func TestRequestCodec(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleXML))
+	defer srv.Close()
+	s := Session{}
+	res := structType{}
+	r := Request{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  "POST",
+		Payload: &fooStruct,
+		Result:  &res,
+		Codec:   XMLCodec{},
+	}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, barStruct, res)
+	unmarshaled := structType{}
+	assert.Nil(t, resp.Unmarshal(&unmarshaled))
+	assert.Equal(t, barStruct, unmarshaled)
+}
+
+func TestDecodeByContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Accept") != "application/json" {
+			http.Error(w, "Bad accept header", http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/x-upper")
+		w.Write([]byte("NAPPING"))
+	}))
+	defer srv.Close()
+	s := Session{Codecs: []Codec{upperCodec{}}}
+	var res string
+	resp, err := s.Get("http://"+srv.Listener.Addr().String(), nil, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, "NAPPING", res)
+}
+
+func TestMediaTypeMatches(t *testing.T) {
+	assert.True(t, mediaTypeMatches("application/json", "application/json"))
+	assert.True(t, mediaTypeMatches("application/json", "application/problem+json"))
+	assert.True(t, mediaTypeMatches("application/xml", "text/xml"))
+	assert.False(t, mediaTypeMatches("application/json", "application/xml"))
+	assert.False(t, mediaTypeMatches("application/json", "text/plain"))
+}
+
+func TestErrorDecodeFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html><body>Bad Gateway</body></html>"))
+	}))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	e := errorStruct{}
+	resp, err := Get(u, nil, nil, &e)
+	assert.Nil(t, err)
+	assert.Equal(t, 502, resp.Status())
+	assert.NotNil(t, resp.DecodeError())
+	assert.Equal(t, errorStruct{}, e)
+	//
+	// The failure is also carried by an HTTPError
+	//
+	s := Session{HTTPErrors: true}
+	_, err = s.Get(u, nil, nil, &e)
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		t.Fatalf("Expected *HTTPError, got %v", err)
+	}
+	assert.NotNil(t, httpErr.DecodeErr)
+	//
+	// Successful decoding leaves no error behind
+	//
+	srv2 := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv2.Close()
+	p := Params{"bad": "value"}.AsUrlValues()
+	resp, err = Get("http://"+srv2.Listener.Addr().String(), &p, nil, &e)
+	assert.Nil(t, err)
+	assert.Nil(t, resp.DecodeError())
+	assert.Equal(t, 500, e.Status)
+}
+
+func TestDecodePolicy(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultipleChoices)
+		w.Write([]byte(`{"Foo": 300, "Bar": "choices"}`))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"Foo": 404, "Bar": "missing"}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	//
+	// 3xx responses are left raw by default
+	//
+	res := structType{}
+	e := structType{}
+	resp, err := Get(u, nil, &res, &e)
+	assert.Nil(t, err)
+	assert.Equal(t, 300, resp.Status())
+	assert.Equal(t, structType{}, res)
+	assert.Equal(t, structType{}, e)
+	//
+	// A policy can route them elsewhere
+	//
+	s := Session{
+		DecodePolicy: &DecodePolicy{
+			Result: []StatusRange{{200, 399}, {404, 404}},
+			Error:  []StatusRange{{400, 403}, {405, 599}},
+		},
+		HTTPErrors: true,
+	}
+	_, err = s.Get(u, nil, &res, &e)
+	assert.Nil(t, err)
+	assert.Equal(t, structType{300, "choices"}, res)
+	_, err = s.Get(u+"/missing", nil, &res, &e)
+	assert.Nil(t, err)
+	assert.Equal(t, structType{404, "missing"}, res)
+	assert.Equal(t, structType{}, e)
+	//
+	// Per request
+	//
+	res = structType{}
+	r := Request{
+		Url:          u + "/missing",
+		Method:       "GET",
+		Result:       &res,
+		Error:        &e,
+		DecodePolicy: &DefaultDecodePolicy,
+	}
+	_, err = s.Send(&r)
+	assert.IsType(t, &HTTPError{}, err)
+	assert.Equal(t, structType{}, res)
+	assert.Equal(t, structType{404, "missing"}, e)
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements a cookie jar that can be saved to a file.
+*/
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieJar is an http.CookieJar following RFC 6265.  Cookies cannot be set
+// for a public suffix such as "co.uk".  Unlike the jar of net/http/cookiejar,
+// its cookies can be inspected, changed and saved to a file.  The zero value
+// is ready to use, and it is safe for concurrent use.
+type CookieJar struct {
+	// PublicSuffixList decides which domains cookies may be set for.  If
+	// nil, the list of golang.org/x/net/publicsuffix is used.
+	PublicSuffixList cookiejar.PublicSuffixList
+
+	mu      sync.Mutex
+	entries map[string]*jarEntry // By domain, path and name
+	now     func() time.Time
+}
+
+// jarEntry is a stored cookie, and the format of the cookie file.
+type jarEntry struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	HostOnly bool      `json:"host_only,omitempty"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+	SameSite string    `json:"same_site,omitempty"`
+	Expires  time.Time `json:"expires,omitzero"` // Zero for session cookies
+	Created  time.Time `json:"created"`
+}
+
+func (e *jarEntry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+// cookie returns e as an http.Cookie, with all its attributes.
+func (e *jarEntry) cookie() *http.Cookie {
+	c := &http.Cookie{
+		Name:     e.Name,
+		Value:    e.Value,
+		Path:     e.Path,
+		Domain:   e.Domain,
+		Expires:  e.Expires,
+		Secure:   e.Secure,
+		HttpOnly: e.HttpOnly,
+	}
+	switch e.SameSite {
+	case "Lax":
+		c.SameSite = http.SameSiteLaxMode
+	case "Strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "None":
+		c.SameSite = http.SameSiteNoneMode
+	}
+	return c
+}
+
+// matches returns true if e is sent to host; path is checked unless empty.
+func (e *jarEntry) matches(host, path string, https bool) bool {
+	if e.Secure && !https {
+		return false
+	}
+	if host != e.Domain && (e.HostOnly || !strings.HasSuffix(host, "."+e.Domain)) {
+		return false
+	}
+	if path == "" || path == e.Path {
+		return true
+	}
+	return strings.HasPrefix(path, e.Path) &&
+		(strings.HasSuffix(e.Path, "/") || path[len(e.Path)] == '/')
+}
+
+// cookieFile is the format of a saved CookieJar.
+type cookieFile struct {
+	Cookies []*jarEntry `json:"cookies"`
+}
+
+func (j *CookieJar) time() time.Time {
+	if j.now != nil {
+		return j.now()
+	}
+	return time.Now()
+}
+
+func (j *CookieJar) publicSuffix(domain string) string {
+	if j.PublicSuffixList != nil {
+		return j.PublicSuffixList.PublicSuffix(domain)
+	}
+	return publicsuffix.List.PublicSuffix(domain)
+}
+
+// expire deletes the expired cookies; j.mu must be held.
+func (j *CookieJar) expire(now time.Time) {
+	for id, e := range j.entries {
+		if !e.Expires.IsZero() && !e.Expires.After(now) {
+			delete(j.entries, id)
+		}
+	}
+}
+
+// SetCookies stores the cookies received in a response from u.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return
+	}
+	j.setCookies(jarHost(u.Host), u.Path, cookies)
+}
+
+func (j *CookieJar) setCookies(host, path string, cookies []*http.Cookie) {
+	if host == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.entries == nil {
+		j.entries = map[string]*jarEntry{}
+	}
+	now := j.time()
+	for _, c := range cookies {
+		e := &jarEntry{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			Created:  now,
+		}
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = defaultCookiePath(path)
+		}
+		var ok bool
+		if e.Domain, e.HostOnly, ok = j.domain(host, c.Domain); !ok {
+			continue
+		}
+		switch c.SameSite {
+		case http.SameSiteLaxMode:
+			e.SameSite = "Lax"
+		case http.SameSiteStrictMode:
+			e.SameSite = "Strict"
+		case http.SameSiteNoneMode:
+			e.SameSite = "None"
+		}
+		switch {
+		case c.MaxAge < 0:
+			delete(j.entries, e.id())
+			continue
+		case c.MaxAge > 0:
+			e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			if !c.Expires.After(now) {
+				delete(j.entries, e.id())
+				continue
+			}
+			e.Expires = c.Expires
+		}
+		if old, ok := j.entries[e.id()]; ok {
+			e.Created = old.Created
+		}
+		j.entries[e.id()] = e
+	}
+}
+
+// domain returns the domain a cookie set by host is stored for, whether it
+// is only sent to host itself, and false if the cookie must be rejected.
+func (j *CookieJar) domain(host, domain string) (string, bool, bool) {
+	if domain == "" {
+		return host, true, true
+	}
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if net.ParseIP(host) != nil || strings.Contains(host, ":") {
+		return host, true, domain == host
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	if j.publicSuffix(domain) == domain {
+		// A public suffix may only set cookies for itself
+		return host, true, host == domain
+	}
+	return domain, false, true
+}
+
+// Cookies returns the cookies to send in a request to u.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	entries := j.find(jarHost(u.Host), path, u.Scheme == "https")
+	var cookies []*http.Cookie
+	for _, e := range entries {
+		cookies = append(cookies, &http.Cookie{Name: e.Name, Value: e.Value})
+	}
+	return cookies
+}
+
+// find returns the cookies sent to host, longest paths first.
+func (j *CookieJar) find(host, path string, https bool) []*jarEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire(j.time())
+	var entries []*jarEntry
+	for _, e := range j.entries {
+		if e.matches(host, path, https) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		if len(entries[a].Path) != len(entries[b].Path) {
+			return len(entries[a].Path) > len(entries[b].Path)
+		}
+		if !entries[a].Created.Equal(entries[b].Created) {
+			return entries[a].Created.Before(entries[b].Created)
+		}
+		return entries[a].id() < entries[b].id()
+	})
+	return entries
+}
+
+// HostCookies returns every cookie sent to host, whatever the path, with
+// all their attributes.
+func (j *CookieJar) HostCookies(host string) []*http.Cookie {
+	var cookies []*http.Cookie
+	for _, e := range j.find(jarHost(host), "", true) {
+		cookies = append(cookies, e.cookie())
+	}
+	return cookies
+}
+
+// AddCookies stores cookies as if host had set them in a response to a
+// request for "/".
+func (j *CookieJar) AddCookies(host string, cookies ...*http.Cookie) {
+	j.setCookies(jarHost(host), "/", cookies)
+}
+
+// Clear deletes every cookie sent to host.  If host is empty, the jar is
+// emptied.
+func (j *CookieJar) Clear(host string) {
+	host = jarHost(host)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for id, e := range j.entries {
+		if host == "" || e.matches(host, "", true) {
+			delete(j.entries, id)
+		}
+	}
+}
+
+// Len returns the number of cookies in the jar.
+func (j *CookieJar) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire(j.time())
+	return len(j.entries)
+}
+
+// WriteTo writes the cookies of the jar as JSON, sorted by domain, path and
+// name.  Session cookies are included, so a program that reloads them
+// resumes its sessions.
+func (j *CookieJar) WriteTo(w io.Writer) (int64, error) {
+	j.mu.Lock()
+	j.expire(j.time())
+	f := cookieFile{Cookies: []*jarEntry{}}
+	for _, e := range j.entries {
+		f.Cookies = append(f.Cookies, e)
+	}
+	j.mu.Unlock()
+	sort.Slice(f.Cookies, func(a, b int) bool {
+		return f.Cookies[a].id() < f.Cookies[b].id()
+	})
+	b, err := json.MarshalIndent(&f, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+// ReadFrom replaces the cookies of the jar with those written by WriteTo.
+// Cookies that have since expired are dropped.
+func (j *CookieJar) ReadFrom(r io.Reader) (int64, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(b)), err
+	}
+	var f cookieFile
+	if err = json.Unmarshal(b, &f); err != nil {
+		return int64(len(b)), err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = map[string]*jarEntry{}
+	for _, e := range f.Cookies {
+		j.entries[e.id()] = e
+	}
+	j.expire(j.time())
+	return int64(len(b)), nil
+}
+
+// Save writes the jar to the file path, creating its directory if needed.
+// The file is only readable by its owner, as cookies are credentials.
+func (j *CookieJar) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = j.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Load replaces the cookies of the jar with those saved to the file path.
+// A missing file leaves the jar empty.
+func (j *CookieJar) Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		j.Clear("")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = j.ReadFrom(f)
+	return err
+}
+
+// jarHost returns the canonical form of a host, without its port.
+func jarHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return strings.ToLower(host)
+}
+
+// defaultCookiePath returns the default path of cookies set by a response
+// to path (RFC 6265, section 5.1.4).
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || path[0] != '/' {
+		return "/"
+	}
+	return path[:i]
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cookieNames(cookies []*http.Cookie) string {
+	var names []string
+	for _, c := range cookies {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestCookieJar(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	j := &CookieJar{now: func() time.Time { return now }}
+	u, _ := url.Parse("https://www.example.co.uk:8443/a/b")
+	j.SetCookies(u, []*http.Cookie{
+		{Name: "host", Value: "1"},
+		{Name: "domain", Value: "2", Domain: ".example.co.uk", Path: "/"},
+		{Name: "suffix", Value: "3", Domain: "co.uk"},
+		{Name: "other", Value: "4", Domain: "example.com"},
+		{Name: "secure", Value: "5", Secure: true, Path: "/a/b/c"},
+		{Name: "short", Value: "6", MaxAge: 60},
+	})
+	assert.Equal(t, 4, j.Len())
+	get := func(rawurl string) string {
+		u, _ := url.Parse(rawurl)
+		return cookieNames(j.Cookies(u))
+	}
+	assert.Equal(t, "secure host short domain", get("https://www.example.co.uk/a/b/c/d"))
+	assert.Equal(t, "host short domain", get("http://www.example.co.uk/a/b/c"))
+	assert.Equal(t, "domain", get("http://www.example.co.uk/"))
+	assert.Equal(t, "domain", get("http://api.example.co.uk/a/x"))
+	assert.Equal(t, "", get("http://other.co.uk/"))
+	assert.Equal(t, "", get("ftp://www.example.co.uk/a"))
+	//
+	// Expiry and deletion
+	//
+	now = now.Add(time.Minute)
+	assert.Equal(t, "host domain", get("http://www.example.co.uk/a/x"))
+	j.SetCookies(u, []*http.Cookie{{Name: "host", MaxAge: -1}})
+	assert.Equal(t, "domain", get("http://www.example.co.uk/a/x"))
+	//
+	// Per host
+	//
+	j.AddCookies("api.example.co.uk", &http.Cookie{Name: "api", Value: "7", Expires: now.Add(time.Hour)})
+	cookies := j.HostCookies("API.example.co.uk:443")
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "/", cookies[0].Path)
+		assert.Equal(t, "example.co.uk", cookies[0].Domain)
+		assert.Equal(t, now.Add(time.Hour), cookies[1].Expires)
+	}
+	j.Clear("api.example.co.uk")
+	assert.Equal(t, "secure", cookieNames(j.HostCookies("www.example.co.uk")))
+	j.Clear("")
+	assert.Equal(t, 0, j.Len())
+}
+
+func TestCookieJarSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "napping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jar", "cookies.json")
+	j := &CookieJar{}
+	j.AddCookies("example.com",
+		&http.Cookie{Name: "b", Value: "2", HttpOnly: true, SameSite: http.SameSiteStrictMode},
+		&http.Cookie{Name: "a", Value: "1", Domain: "example.com", MaxAge: 7200},
+		&http.Cookie{Name: "old", Value: "1", MaxAge: 1},
+	)
+	j.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if err = j.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(saved), `"old"`, "expired cookies are dropped")
+	assert.Equal(t, 1, strings.Count(string(saved), `"expires"`), "session cookies have no expiry")
+	j2 := &CookieJar{}
+	if err = j2.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	j2.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if err = j2.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	resaved, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(saved), string(resaved), "the format is stable")
+	assert.Equal(t, "a b", cookieNames(j2.HostCookies("example.com")))
+	assert.Equal(t, "a", cookieNames(j2.HostCookies("www.example.com")))
+	c := j2.HostCookies("example.com")[1]
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, c.SameSite)
+	assert.Nil(t, j2.Load(filepath.Join(dir, "missing.json")))
+	assert.Equal(t, 0, j2.Len())
+}
+
+func TestSessionCookies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "jtkirk", Path: "/"})
+			return
+		}
+		if c, err := req.Cookie("session"); err != nil || c.Value != "jtkirk" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	s := Session{}
+	resp, err := s.Get(u+"/private", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, resp.Status())
+	if _, err = s.Post(u+"/login", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s.Get(u+"/private", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	//
+	// A new Session resumes with the saved cookies
+	//
+	dir, err := ioutil.TempDir("", "napping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cookies.json")
+	if err = s.Jar.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	s2 := Session{Jar: &CookieJar{}}
+	if err = s2.Jar.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = s2.Get(u+"/private", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+}
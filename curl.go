@@ -0,0 +1,210 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the export of requests as curl commands.
+*/
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Curl returns a curl command reproducing r as the Session would send it,
+// without sending it.  If redact is true, the password and the secrets
+// described by the Session's Redaction are replaced.  Credentials added when
+// sending, by the Authenticator or the Signer, are not included.
+func (s *Session) Curl(r *Request, redact bool) (string, error) {
+	c := *r
+	p, err := s.prepare(&c)
+	if err != nil {
+		return "", err
+	}
+	return p.curl(&c, redact), nil
+}
+
+// Curl returns a curl command reproducing the request that was sent, without
+// the credentials added by the Authenticator or the Signer.  See
+// Session.Curl.
+func (r *Response) Curl(redact bool) string {
+	if r.prepared == nil {
+		return ""
+	}
+	req := Request(*r)
+	return r.prepared.curl(&req, redact)
+}
+
+// curl renders the prepared request r as a curl command.
+func (p *prepared) curl(r *Request, redact bool) string {
+	rd := &Redaction{}
+	if redact {
+		rd = p.redaction
+	}
+	m, multi := r.Payload.(*Multipart)
+	multi = multi && m != nil && !r.RawPayload
+	var data []byte
+	var piped bool
+	if !multi {
+		// Files of multipart payloads are referred to, never read
+		data, piped = curlData(p.body)
+	}
+	args := []string{"curl"}
+	switch {
+	case r.Method == "HEAD":
+		args = append(args, "--head")
+	case r.Method != "GET" && r.Method != "":
+		args = append(args, "-X", shellQuote(r.Method))
+	case p.body != nil:
+		args = append(args, "-X", "GET")
+	}
+	//
+	// Headers.  Basic authentication is given as credentials, and curl
+	// chooses its own multipart boundary.
+	//
+	header := rd.header(p.header)
+	if p.userinfo != nil {
+		header.Del("Authorization")
+		user := p.userinfo.Username()
+		if pwd, ok := p.userinfo.Password(); ok {
+			if redact {
+				pwd = Redacted
+			}
+			user += ":" + pwd
+		}
+		args = append(args, "-u", shellQuote(user))
+	}
+	if multi {
+		header.Del("Content-Type")
+	}
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+	//
+	// Body
+	//
+	var stdin string
+	switch {
+	case multi:
+		args = append(args, curlForm(m, rd)...)
+	case piped:
+		args = append(args, "--data-binary", "@-")
+	case data != nil && !utf8.Valid(data):
+		stdin = "echo " + base64.StdEncoding.EncodeToString(data) + " | base64 -d | "
+		args = append(args, "--data-binary", "@-")
+	case data != nil:
+		args = append(args, "--data-binary", shellQuote(rd.payload(data, p.header.Get("Content-Type"))))
+	}
+	u := *p.url
+	u.User = nil
+	args = append(args, shellQuote(rd.url(&u)))
+	return stdin + strings.Join(args, " ")
+}
+
+// curlData returns the content of b.  It reports true if the content cannot
+// be known without consuming the payload, and must be piped to curl.
+func curlData(b *body) ([]byte, bool) {
+	switch {
+	case b == nil:
+		return nil, false
+	case b.data != nil:
+		return b.data, false
+	case b.getBody != nil && !b.once:
+		rc, err := b.getBody()
+		if err != nil {
+			return nil, true
+		}
+		defer rc.Close()
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, true
+		}
+		return data, false
+	}
+	return nil, true
+}
+
+// curlForm returns the curl arguments sending the fields and files of m.
+// Files that are not read from disk are referred to by their file name.
+func curlForm(m *Multipart, rd *Redaction) []string {
+	var args []string
+	keys := make([]string, 0, len(m.Fields))
+	for k := range m.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range m.Fields[k] {
+			if rd.isParam(k) {
+				v = Redacted
+			}
+			args = append(args, "--form-string", shellQuote(k+"="+v))
+		}
+	}
+	for _, f := range m.Files {
+		path := f.Path
+		if path == "" {
+			path = f.Filename
+		}
+		form := f.Field + "=@" + path
+		if f.Filename != "" && f.Filename != filepath.Base(path) {
+			form += ";filename=" + f.Filename
+		}
+		if f.ContentType != "" {
+			form += ";type=" + f.ContentType
+		}
+		args = append(args, "-F", shellQuote(form))
+	}
+	return args
+}
+
+// payload returns a text body of the given Content-Type with secret fields
+// replaced.  Unlike body, it neither reformats nor truncates the body.
+func (rd *Redaction) payload(data []byte, contentType string) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case len(rd.Fields) > 0:
+		if v, ok := rd.json(data); ok {
+			if b, err := json.Marshal(v); err == nil {
+				return string(b)
+			}
+		}
+	case len(rd.Params) > 0 && mt == "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(data)); err == nil {
+			return rd.values(form).Encode()
+		}
+	}
+	return string(data)
+}
+
+// parses reports whether payload needs to parse bodies of contentType to
+// replace their secrets.
+func (rd *Redaction) parses(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return len(rd.Fields) > 0 || (len(rd.Params) > 0 && mt == "application/x-www-form-urlencoded")
+}
+
+// shellQuote quotes s for a POSIX shell, unless it is safe as it is.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:@%+=,") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurl(t *testing.T) {
+	p := Params{"page": "1", "api_key": "hunter2"}.AsUrlValues()
+	h := http.Header{}
+	h.Set("X-Trace", "it's me")
+	s := Session{
+		Params:   &p,
+		Header:   &h,
+		Userinfo: url.UserPassword("jtkirk", "hunter2"),
+		Redaction: &Redaction{
+			Params: []string{"api_key"},
+			Fields: []string{"Bar"},
+		},
+	}
+	r := Request{
+		Url:     "http://example.com/items?sort=asc",
+		Method:  "post",
+		Payload: &fooStruct,
+	}
+	cmd, err := s.Curl(&r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `curl -X POST -u jtkirk:hunter2 -H 'Accept: application/json' -H 'Content-Type: application/json' -H 'X-Trace: it'\''s me' --data-binary '{"Foo":111,"Bar":"foo"}' 'http://example.com/items?api_key=hunter2&page=1&sort=asc'`, cmd)
+	cmd, err = s.Curl(&r, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `curl -X POST -u jtkirk:REDACTED -H 'Accept: application/json' -H 'Content-Type: application/json' -H 'X-Trace: it'\''s me' --data-binary '{"Bar":"REDACTED","Foo":111}' 'http://example.com/items?api_key=REDACTED&page=1&sort=asc'`, cmd)
+	// The request itself is left alone
+	assert.Equal(t, "post", r.Method)
+	assert.Nil(t, r.Params)
+}
+
+func TestResponseCurl(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	s := Session{}
+	r := Request{
+		Url:     u,
+		Method:  "POST",
+		Payload: &fooStruct,
+		Header:  &http.Header{"Authorization": []string{"Bearer hunter2"}},
+	}
+	expected, err := s.Curl(&r, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, resp.Curl(true))
+	assert.Contains(t, resp.Curl(true), "'Authorization: REDACTED'")
+	assert.Contains(t, resp.Curl(false), "'Authorization: Bearer hunter2'")
+	assert.Equal(t, "", (&Response{}).Curl(false))
+}
+
+func TestCurlBodies(t *testing.T) {
+	s := Session{}
+	//
+	// Bodies without a payload
+	//
+	cmd, err := s.Curl(&Request{Url: "http://example.com", Method: "HEAD"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "curl --head -H 'Accept: application/json' http://example.com", cmd)
+	//
+	// Streams that can only be read once are piped in
+	//
+	r := Request{
+		Url:        "http://example.com",
+		Method:     "PUT",
+		Payload:    struct{ *strings.Reader }{strings.NewReader("streamed")},
+		RawPayload: true,
+	}
+	cmd, err = s.Curl(&r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasSuffix(cmd, "--data-binary @- http://example.com"), cmd)
+	//
+	// Binary data is decoded from base64
+	//
+	r.Payload = bytes.NewBuffer([]byte{0xff, 0x00, 0xfe})
+	cmd, err = s.Curl(&r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(cmd, "echo /wD+ | base64 -d | curl -X PUT "), cmd)
+	//
+	// Multipart forms
+	//
+	r = Request{
+		Url:    "http://example.com/upload",
+		Method: "POST",
+		Payload: &Multipart{
+			Fields: url.Values{"title": {"@home"}},
+			Files: []FormFile{
+				{Field: "doc", Path: "/tmp/report.pdf", ContentType: "application/pdf"},
+				{Field: "note", Filename: "note.txt", Data: []byte("hi")},
+			},
+		},
+	}
+	cmd, err = s.Curl(&r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "curl -X POST -H 'Accept: application/json' --form-string title=@home -F 'doc=@/tmp/report.pdf;type=application/pdf' -F note=@note.txt http://example.com/upload", cmd)
+	//
+	// The files are not read
+	//
+	p := &prepared{
+		url:    &url.URL{Scheme: "http", Host: "example.com"},
+		header: http.Header{},
+		body: &body{getBody: func() (io.ReadCloser, error) {
+			t.Error("multipart payload read")
+			return nil, io.EOF
+		}},
+	}
+	p.curl(&r, false)
+}
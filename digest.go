@@ -0,0 +1,235 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements HTTP Digest access authentication (RFC 7616).
+*/
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Digest authenticates requests with HTTP Digest authentication, using the
+// MD5 or SHA-256 algorithms and qop=auth.  The first request is sent without
+// credentials; once the server has sent a challenge, its nonce is reused by
+// subsequent requests until the server declares it stale.  It is safe for
+// concurrent use.
+type Digest struct {
+	Username string
+	Password string
+
+	mu        sync.Mutex
+	challenge map[string]string // Parameters of the current challenge
+	nc        int               // Number of requests sent with its nonce
+	cnonce    func() string     // Generates client nonces
+}
+
+// Authenticate sets the Authorization header of req, if the server has sent
+// a challenge.
+func (d *Digest) Authenticate(req *http.Request) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.challenge == nil {
+		return nil
+	}
+	d.nc++
+	cnonce := d.cnonce
+	if cnonce == nil {
+		cnonce = randomNonce
+	}
+	auth, err := digestAuthorization(d.challenge, d.Username, d.Password, req.Method, req.URL.RequestURI(), d.nc, cnonce())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	return nil
+}
+
+// Challenge accepts the Digest challenge of resp.  It returns false if the
+// server rejected credentials computed with a nonce that is still valid,
+// i.e. if they are wrong.
+func (d *Digest) Challenge(req *http.Request, resp *http.Response) (bool, error) {
+	var challenge map[string]string
+	for _, c := range parseChallenges(resp.Header.Values("WWW-Authenticate")) {
+		if !strings.EqualFold(c["scheme"], "Digest") || digestHash(c["algorithm"]) == nil {
+			continue
+		}
+		// Prefer SHA-256 when the server offers a choice
+		if challenge == nil || strings.HasPrefix(strings.ToUpper(c["algorithm"]), "SHA-256") {
+			challenge = c
+		}
+	}
+	if challenge == nil {
+		return false, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sent := strings.HasPrefix(req.Header.Get("Authorization"), "Digest ")
+	if sent && d.challenge != nil && d.challenge["nonce"] == challenge["nonce"] &&
+		!strings.EqualFold(challenge["stale"], "true") {
+		return false, nil
+	}
+	d.challenge = challenge
+	d.nc = 0
+	return true, nil
+}
+
+// digestHash returns the hash function of a Digest algorithm, or nil if it
+// is not supported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// digestAuthorization returns the Authorization header answering a
+// challenge for a request with the given method and URI.
+func digestAuthorization(challenge map[string]string, username, password, method, uri string, nc int, cnonce string) (string, error) {
+	newHash := digestHash(challenge["algorithm"])
+	if newHash == nil {
+		return "", fmt.Errorf("napping: unsupported digest algorithm %q", challenge["algorithm"])
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	realm, nonce := challenge["realm"], challenge["nonce"]
+	ha1 := h(username + ":" + realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(challenge["algorithm"]), "-SESS") {
+		ha1 = h(ha1 + ":" + nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+	//
+	// qop=auth, or the original RFC 2069 scheme if the server offers no qop
+	//
+	qop := ""
+	if q, ok := challenge["qop"]; ok {
+		for _, v := range strings.Split(q, ",") {
+			if strings.TrimSpace(v) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", fmt.Errorf("napping: unsupported digest qop %q", q)
+		}
+	}
+	ncValue := fmt.Sprintf("%08x", nc)
+	var response string
+	if qop == "" {
+		response = h(ha1 + ":" + nonce + ":" + ha2)
+	} else {
+		response = h(ha1 + ":" + nonce + ":" + ncValue + ":" + cnonce + ":" + qop + ":" + ha2)
+	}
+	params := []string{
+		"username=" + quoted(username),
+		"realm=" + quoted(realm),
+		"nonce=" + quoted(nonce),
+		"uri=" + quoted(uri),
+	}
+	if a, ok := challenge["algorithm"]; ok {
+		params = append(params, "algorithm="+a)
+	}
+	if qop != "" {
+		params = append(params, "qop="+qop, "nc="+ncValue, "cnonce="+quoted(cnonce))
+	}
+	params = append(params, "response="+quoted(response))
+	if o, ok := challenge["opaque"]; ok {
+		params = append(params, "opaque="+quoted(o))
+	}
+	return "Digest " + strings.Join(params, ", "), nil
+}
+
+// parseChallenges parses the challenges of WWW-Authenticate headers into
+// their parameters.  The auth scheme of each is stored as "scheme".
+func parseChallenges(headers []string) []map[string]string {
+	var challenges []map[string]string
+	for _, s := range headers {
+		var c map[string]string
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			n := tokenLen(s)
+			if n == 0 {
+				break
+			}
+			token := s[:n]
+			rest := strings.TrimLeft(s[n:], " \t")
+			if c == nil || !strings.HasPrefix(rest, "=") {
+				c = map[string]string{"scheme": token}
+				challenges = append(challenges, c)
+				s = rest
+				continue
+			}
+			var value string
+			value, s = parseParamValue(strings.TrimLeft(rest[1:], " \t"))
+			c[strings.ToLower(token)] = value
+		}
+	}
+	return challenges
+}
+
+// tokenLen returns the length of the token at the start of s.
+func tokenLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(s[i])) &&
+			!('0' <= s[i] && s[i] <= '9') && !('a' <= s[i] && s[i] <= 'z') && !('A' <= s[i] && s[i] <= 'Z') {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// parseParamValue parses the token or quoted string at the start of s,
+// returning it and the rest of s.
+func parseParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexAny(s, ", \t")
+		if i < 0 {
+			return s, ""
+		}
+		return s[:i], s[i:]
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// quoted returns s as a quoted string.
+func quoted(s string) string {
+	return `"` + quoteEscaper.Replace(s) + `"`
+}
+
+// randomNonce returns a random client nonce.
+func randomNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The example of RFC 7616, section 3.9.1
+var rfc7616Challenge = map[string]string{
+	"realm":  "http-auth@example.org",
+	"qop":    "auth, auth-int",
+	"nonce":  "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v",
+	"opaque": "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS",
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	const cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	c := map[string]string{"algorithm": "MD5"}
+	for k, v := range rfc7616Challenge {
+		c[k] = v
+	}
+	auth, err := digestAuthorization(c, "Mufasa", "Circle of Life", "GET", "/dir/index.html", 1, cnonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `Digest username="Mufasa", realm="http-auth@example.org", `+
+		`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", uri="/dir/index.html", `+
+		`algorithm=MD5, qop=auth, nc=00000001, cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", `+
+		`response="8ca523f5e9506fed4657c9700eebdbec", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`, auth)
+	c["algorithm"] = "SHA-256"
+	auth, err = digestAuthorization(c, "Mufasa", "Circle of Life", "GET", "/dir/index.html", 1, cnonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, auth, `response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"`)
+	c["algorithm"] = "SHA-512-256"
+	_, err = digestAuthorization(c, "Mufasa", "Circle of Life", "GET", "/dir/index.html", 1, cnonce)
+	assert.NotNil(t, err)
+}
+
+func TestParseChallenges(t *testing.T) {
+	cs := parseChallenges([]string{
+		`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="a\"b", Basic realm="x"`,
+		`Bearer`,
+	})
+	assert.Equal(t, []map[string]string{
+		{"scheme": "Digest", "realm": "http-auth@example.org", "qop": "auth, auth-int", "algorithm": "SHA-256", "nonce": `a"b`},
+		{"scheme": "Basic", "realm": "x"},
+		{"scheme": "Bearer"},
+	}, cs)
+}
+
+// digestServer requires Digest authentication of jtkirk, and echoes the
+// bodies of POST requests.  Each nonce is good for maxUses requests.
+type digestServer struct {
+	*httptest.Server
+	maxUses int
+
+	mu         sync.Mutex
+	nonces     int
+	nc         int
+	challenges int
+}
+
+func newDigestServer(maxUses int) *digestServer {
+	d := &digestServer{maxUses: maxUses}
+	echo := flakyHandler(0, 0, new(int32))
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		nonce := fmt.Sprintf("nonce%d", d.nonces)
+		challenge := map[string]string{
+			"realm":     "napping",
+			"nonce":     nonce,
+			"qop":       "auth",
+			"algorithm": "SHA-256",
+			"opaque":    "opaque",
+		}
+		stale := false
+		if cs := parseChallenges([]string{req.Header.Get("Authorization")}); len(cs) == 1 && cs[0]["scheme"] == "Digest" {
+			a := cs[0]
+			nc, _ := strconv.ParseInt(a["nc"], 16, 0)
+			expected, _ := digestAuthorization(challenge, "jtkirk", "hunter2", req.Method, req.URL.RequestURI(), int(nc), a["cnonce"])
+			switch {
+			case a["nonce"] != nonce:
+				stale = true
+			case int(nc) <= d.nc:
+				// Replayed
+			case expected == req.Header.Get("Authorization"):
+				d.nc = int(nc)
+				if d.nc >= d.maxUses {
+					d.nonces++
+					d.nc = 0
+				}
+				echo(w, req)
+				return
+			}
+		}
+		d.challenges++
+		w.Header().Add("WWW-Authenticate", `Basic realm="napping"`)
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="napping", nonce=%q, qop="auth", algorithm=MD5, opaque="opaque"`, nonce))
+		w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Digest realm="napping", nonce=%q, qop="auth", algorithm=SHA-256, opaque="opaque", stale=%t`, nonce, stale))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	return d
+}
+
+func TestDigest(t *testing.T) {
+	d := newDigestServer(3)
+	defer d.Close()
+	u := "http://" + d.Listener.Addr().String()
+	s := Session{Auth: &Digest{Username: "jtkirk", Password: "hunter2"}}
+	//
+	// The first request is challenged, and replayed with its payload
+	//
+	res := structType{}
+	resp, err := s.Post(u+"/a?b=c", &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, fooStruct, res)
+	assert.Equal(t, 1, d.challenges)
+	//
+	// The nonce is reused until it is stale
+	//
+	for i := 0; i < 4; i++ {
+		res = structType{}
+		resp, err = s.Post(u, &barStruct, &res, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 200, resp.Status())
+		assert.Equal(t, barStruct, res)
+	}
+	assert.Equal(t, 2, d.challenges)
+	//
+	// Wrong credentials are not replayed endlessly
+	//
+	s.Auth = &Digest{Username: "jtkirk", Password: "wrong"}
+	resp, err = s.Get(u, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, resp.Status())
+	assert.Equal(t, 4, d.challenges)
+	resp, err = s.Get(u, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, resp.Status())
+	assert.Equal(t, 5, d.challenges)
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the errors returned for unsuccessful responses.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MaxErrorBody is the number of bytes of the response body kept in an
+// HTTPError.
+var MaxErrorBody = 512
+
+// An HTTPError is returned by Send, along with the Response, for responses
+// with an error status when Session.HTTPErrors is set.  Which statuses are
+// errors is decided by the DecodePolicy.
+type HTTPError struct {
+	StatusCode int
+	Method     string
+	URL        string // Request URL, with its secrets redacted
+	Header     http.Header
+	Body       string      // Response body, truncated to MaxErrorBody bytes
+	Value      interface{} // The Request's Error, decoded from the body
+	DecodeErr  error       // Error decoding the body into Value, if any
+}
+
+// newHTTPError returns an HTTPError describing response, with the secrets
+// described by rd redacted from its URL.
+func newHTTPError(response *Response, rd *Redaction) *HTTPError {
+	e := &HTTPError{
+		StatusCode: response.status,
+		Method:     response.Method,
+		Value:      response.Error,
+		DecodeErr:  response.decodeErr,
+	}
+	if resp := response.response; resp != nil {
+		e.Header = resp.Header
+		if resp.Request != nil {
+			e.URL = rd.url(resp.Request.URL)
+		}
+	}
+	body := response.RawText()
+	if len(body) > MaxErrorBody {
+		body = body[:MaxErrorBody] + "..."
+	}
+	e.Body = body
+	return e
+}
+
+// Error returns the method, URL, status and body of the response, e.g.
+// "napping: GET http://example.com/foo: 404 Not Found: no such foo".
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("napping: %s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if body := strings.TrimSpace(e.Body); body != "" {
+		msg += ": " + strings.Join(strings.Fields(body), " ")
+	}
+	return msg
+}
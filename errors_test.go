@@ -0,0 +1,81 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandleGet))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	p := Params{"bad": "value"}.AsUrlValues()
+	//
+	// Disabled by default
+	//
+	resp, err := Get(u, &p, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, resp.Status())
+	//
+	// Enabled
+	//
+	s := Session{HTTPErrors: true}
+	e := errorStruct{}
+	resp, err = s.Get(u, &p, nil, &e)
+	assert.NotNil(t, resp)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("Expected *HTTPError, got %v", err)
+	}
+	assert.Equal(t, 500, httpErr.StatusCode)
+	assert.Equal(t, "GET", httpErr.Method)
+	assert.Equal(t, u+"?bad=value", httpErr.URL)
+	assert.Equal(t, &e, httpErr.Value)
+	assert.Equal(t, "Bad query params: bad=value", e.Message)
+	assert.Equal(t, `napping: GET `+u+`?bad=value: 500 Internal Server Error: {"Status":500,"Message":"Bad query params: bad=value"}`, err.Error())
+	//
+	// Successful responses are not errors
+	//
+	p = fooParams.AsUrlValues()
+	_, err = s.Get(u, &p, nil, nil)
+	assert.Nil(t, err)
+}
+
+func TestHTTPErrorTruncated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, strings.Repeat("x", 2*MaxErrorBody), http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	s := Session{HTTPErrors: true}
+	u := "http://jtkirk:secret@" + srv.Listener.Addr().String()
+	_, err := s.Get(u, nil, nil, nil)
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		t.Fatalf("Expected *HTTPError, got %v", err)
+	}
+	assert.Equal(t, 502, httpErr.StatusCode)
+	assert.Equal(t, MaxErrorBody+3, len(httpErr.Body))
+	assert.Equal(t, "text/plain; charset=utf-8", httpErr.Header.Get("Content-Type"))
+	assert.NotContains(t, err.Error(), "secret")
+	//
+	// Secret query parameters are redacted
+	//
+	s.Redaction = &Redaction{Params: []string{"token"}}
+	p := url.Values{"token": {"hunter2"}}
+	_, err = s.Get(u, &p, nil, nil)
+	if assert.IsType(t, &HTTPError{}, err) {
+		assert.Equal(t, "http://jtkirk:xxxxx@"+srv.Listener.Addr().String()+"?token="+Redacted, err.(*HTTPError).URL)
+	}
+	assert.NotContains(t, err.Error(), "hunter2")
+}
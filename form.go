@@ -0,0 +1,247 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the codec for URL-encoded form payloads.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FormCodec encodes payloads as application/x-www-form-urlencoded forms.  The
+// payload may be a url.Values, a Params, a map[string]string, or a struct (or
+// pointer to one).  Struct fields are named by their "form" tag, which, like
+// a "json" tag, may be "-" to skip the field or carry an "omitempty" option;
+// untagged exported fields use the field name.  Slice fields become repeated
+// values.
+//
+// FormCodec leaves the Accept header and the decoding of responses to the
+// Session, so Result and Error are decoded as usual.  Only responses that are
+// themselves form-encoded are decoded by FormCodec, into the same kinds of
+// values it encodes.
+type FormCodec struct{}
+
+// ContentType returns "application/x-www-form-urlencoded".
+func (FormCodec) ContentType() string { return "application/x-www-form-urlencoded" }
+
+// Accept returns "", so the Session's default is used.
+func (FormCodec) Accept() string { return "" }
+
+// Marshal returns the form encoding of v.
+func (FormCodec) Marshal(v interface{}) ([]byte, error) {
+	values, err := formValues(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(values.Encode()), nil
+}
+
+// Unmarshal parses form-encoded data into v.
+func (FormCodec) Unmarshal(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch p := v.(type) {
+	case *url.Values:
+		*p = values
+		return nil
+	case *Params:
+		*p = Params{}
+		for k := range values {
+			(*p)[k] = values.Get(k)
+		}
+		return nil
+	case *map[string]string:
+		*p = map[string]string{}
+		for k := range values {
+			(*p)[k] = values.Get(k)
+		}
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("napping: cannot unmarshal form into %T", v)
+	}
+	return setFormFields(rv.Elem(), values)
+}
+
+// formValues converts a payload to url.Values.
+func formValues(v interface{}) (url.Values, error) {
+	switch p := v.(type) {
+	case url.Values:
+		return p, nil
+	case *url.Values:
+		if p == nil {
+			return url.Values{}, nil
+		}
+		return *p, nil
+	case Params:
+		return p.AsUrlValues(), nil
+	case *Params:
+		if p == nil {
+			return url.Values{}, nil
+		}
+		return p.AsUrlValues(), nil
+	case map[string]string:
+		return Params(p).AsUrlValues(), nil
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return url.Values{}, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("napping: cannot encode %T as a form", v)
+	}
+	values := url.Values{}
+	addFormFields(values, rv)
+	return values, nil
+}
+
+// formField returns the form name of a struct field, and whether it is
+// omitted when empty.  The name is "" if the field is skipped.
+func formField(f reflect.StructField) (name string, omitempty bool) {
+	if f.PkgPath != "" && !f.Anonymous {
+		return "", false
+	}
+	tag := f.Tag.Get("form")
+	if tag == "-" {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+// addFormFields adds the fields of struct rv to values.  The fields of
+// embedded structs are added as if they belonged to rv.
+func addFormFields(values url.Values, rv reflect.Value) {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := rv.Field(i)
+		if f.Anonymous && fv.Kind() == reflect.Struct && f.Tag.Get("form") == "" {
+			addFormFields(values, fv)
+			continue
+		}
+		name, omitempty := formField(f)
+		if name == "" {
+			continue
+		}
+		if omitempty && fv.IsZero() {
+			continue
+		}
+		for fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				break
+			}
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Ptr {
+			continue
+		}
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			for j := 0; j < fv.Len(); j++ {
+				values.Add(name, fmt.Sprint(fv.Index(j).Interface()))
+			}
+			continue
+		}
+		values.Add(name, fmt.Sprint(fv.Interface()))
+	}
+}
+
+// setFormFields sets the fields of struct rv from values.
+func setFormFields(rv reflect.Value, values url.Values) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := rv.Field(i)
+		if f.Anonymous && fv.Kind() == reflect.Struct && f.Tag.Get("form") == "" {
+			if err := setFormFields(fv, values); err != nil {
+				return err
+			}
+			continue
+		}
+		name, _ := formField(f)
+		vs, ok := values[name]
+		if name == "" || !ok || !fv.CanSet() {
+			continue
+		}
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			s := reflect.MakeSlice(fv.Type(), len(vs), len(vs))
+			for j, v := range vs {
+				if err := setFormValue(s.Index(j), v); err != nil {
+					return fmt.Errorf("napping: form field %s: %v", name, err)
+				}
+			}
+			fv.Set(s)
+			continue
+		}
+		if err := setFormValue(fv, vs[0]); err != nil {
+			return fmt.Errorf("napping: form field %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// setFormValue parses s into v, which must be of a basic kind.
+func setFormValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tokenRequest struct {
+	GrantType string   `form:"grant_type"`
+	Scope     []string `form:"scope"`
+	Refresh   string   `form:"refresh_token,omitempty"`
+	Internal  string   `form:"-"`
+	Retries   int
+}
+
+// handleForm answers a form-encoded request with the parsed form, as JSON or,
+// if requested with ?reply=form, form-encoded.
+func handleForm(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		JSONError(w, "Bad content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if req.Header.Get("Accept") != "application/json" {
+		JSONError(w, "Bad accept header", http.StatusNotAcceptable)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.URL.Query().Get("reply") == "form" {
+		w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+		w.Write([]byte(req.PostForm.Encode()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req.PostForm)
+}
+
+func TestFormPayload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleForm))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	tests := []struct {
+		payload  interface{}
+		expected url.Values
+	}{
+		{url.Values{"a": {"1", "2"}}, url.Values{"a": {"1", "2"}}},
+		{Params{"b": "3"}, url.Values{"b": {"3"}}},
+		{map[string]string{"c": "4"}, url.Values{"c": {"4"}}},
+		{
+			&tokenRequest{GrantType: "client_credentials", Scope: []string{"read", "write"}, Internal: "x"},
+			url.Values{"grant_type": {"client_credentials"}, "scope": {"read", "write"}, "Retries": {"0"}},
+		},
+	}
+	for _, test := range tests {
+		res := url.Values{}
+		r := Request{
+			Url:     u,
+			Method:  "POST",
+			Payload: test.payload,
+			Result:  &res,
+			Codec:   FormCodec{},
+		}
+		resp, err := Send(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 200, resp.Status())
+		assert.Equal(t, test.expected, res)
+	}
+}
+
+func TestFormResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleForm))
+	defer srv.Close()
+	s := Session{Codec: FormCodec{}}
+	p := Params{"reply": "form"}.AsUrlValues()
+	payload := tokenRequest{GrantType: "refresh_token", Refresh: "abc", Retries: 3}
+	res := tokenRequest{}
+	r := Request{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  "POST",
+		Params:  &p,
+		Payload: payload,
+		Result:  &res,
+	}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, payload, res)
+	//
+	// Errors are still decoded as JSON
+	//
+	e := errorStruct{}
+	r = Request{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  "POST",
+		Payload: payload,
+		Error:   &e,
+		Header:  &http.Header{"Accept": {"text/html"}},
+	}
+	resp, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 406, resp.Status())
+	assert.Equal(t, "Bad accept header", e.Message)
+}
+
+func TestFormUnsupported(t *testing.T) {
+	_, err := FormCodec{}.Marshal(42)
+	assert.NotNil(t, err)
+	var n int
+	assert.NotNil(t, FormCodec{}.Unmarshal([]byte("a=1"), &n))
+	res := struct{ N int }{}
+	assert.NotNil(t, FormCodec{}.Unmarshal([]byte("N=x"), &res))
+}
+
+func TestFormNilPayload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(t, "", string(body))
+		w.WriteHeader(200)
+	}))
+	defer srv.Close()
+	s := Session{Codec: FormCodec{}}
+	for _, p := range []interface{}{(*url.Values)(nil), (*Params)(nil), (*struct{ N int })(nil)} {
+		resp, err := s.Post(srv.URL, p, nil, nil)
+		if assert.Nil(t, err, "%T", p) {
+			assert.Equal(t, 200, resp.Status())
+		}
+	}
+}
@@ -0,0 +1,504 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the recording of Session traffic as an HTTP Archive
+(HAR 1.2), which can be opened in browser developer tools.
+*/
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A HARRecorder records every exchange of a Session as an entry of an HTTP
+// Archive.  Each attempt to send a request is an entry; redirects followed by
+// the client are part of the entry of the request that caused them.  The zero
+// value records with default bounds, and is safe for concurrent use.
+type HARRecorder struct {
+	// MaxEntries is the number of entries kept; older entries are dropped.
+	// Defaults to 1000.
+	MaxEntries int
+
+	// MaxBodyBytes is the number of bytes of each request and response body
+	// that are recorded.  Defaults to 1MiB; if negative, no bodies are
+	// recorded.
+	MaxBodyBytes int
+
+	// Redaction replaces secrets in the recorded headers, URLs and bodies.
+	// If nil, DefaultRedaction is used.
+	Redaction *Redaction
+
+	mu      sync.Mutex
+	paused  bool
+	entries []harEntry
+	dropped int
+}
+
+// Pause stops recording, keeping the entries recorded so far.
+func (h *HARRecorder) Pause() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.paused = true
+}
+
+// Resume restarts recording after Pause.
+func (h *HARRecorder) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.paused = false
+}
+
+// Recording reports whether exchanges are being recorded.
+func (h *HARRecorder) Recording() bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.paused
+}
+
+// Len returns the number of entries recorded.
+func (h *HARRecorder) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.entries)
+}
+
+// Reset discards all entries.
+func (h *HARRecorder) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = nil
+	h.dropped = 0
+}
+
+// WriteTo writes the archive of the entries recorded so far to w as JSON.
+func (h *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	doc := har{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "napping", Version: moduleVersion()},
+		Entries: append([]harEntry{}, h.entries...),
+	}}
+	if h.dropped > 0 {
+		doc.Log.Comment = fmt.Sprintf("%d earlier entries were dropped", h.dropped)
+	}
+	h.mu.Unlock()
+	blob, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(blob, '\n'))
+	return int64(n), err
+}
+
+// WriteFile writes the archive of the entries recorded so far to the named
+// file, creating or truncating it.
+func (h *HARRecorder) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = h.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// begin starts recording the exchange of req, returning the request to send
+// in its place and the timer of the exchange.  The timer is nil if h is not
+// recording.
+func (h *HARRecorder) begin(req *http.Request) (*http.Request, *harTimer) {
+	if !h.Recording() {
+		return req, nil
+	}
+	t := &harTimer{start: time.Now()}
+	if max := h.maxBodyBytes(); req.GetBody != nil && max >= 0 {
+		if rc, err := req.GetBody(); err == nil {
+			// One byte more than recorded tells whether it is truncated
+			t.reqBody, _ = ioutil.ReadAll(io.LimitReader(rc, int64(max)+1))
+			rc.Close()
+		}
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), t.trace())), t
+}
+
+// record adds the exchange of req to the archive.  body is the response body,
+// unless it was left unread; err is the error that ended the exchange, if
+// any.
+func (h *HARRecorder) record(t *harTimer, req *http.Request, resp *http.Response, body []byte, err error) {
+	if t == nil {
+		return
+	}
+	end := time.Now()
+	rd := h.redaction()
+	e := harEntry{
+		StartedDateTime: t.start.Format(time.RFC3339Nano),
+		Request:         h.request(rd, req, t.reqBody),
+		Response:        harResponse{Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		Cache:           struct{}{},
+		Timings:         t.timings(end),
+	}
+	e.Time = e.Timings.total()
+	if resp != nil {
+		e.Response = h.response(rd, resp, body)
+	}
+	if err != nil {
+		e.Comment = rd.err(err).Error()
+	}
+	max := h.MaxEntries
+	if max <= 0 {
+		max = 1000
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	if n := len(h.entries) - max; n > 0 {
+		h.entries = append(h.entries[:0], h.entries[n:]...)
+		h.dropped += n
+	}
+}
+
+// request returns the HAR rendering of req.
+func (h *HARRecorder) request(rd *Redaction, req *http.Request, body []byte) harRequest {
+	u := *req.URL
+	r := harRequest{
+		Method:      req.Method,
+		URL:         rd.url(&u),
+		HTTPVersion: req.Proto,
+		Cookies:     harCookies(req.Cookies(), rd.isHeader("Cookie")),
+		Headers:     harHeaders(rd.header(req.Header)),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    req.ContentLength,
+	}
+	for k, vs := range rd.values(req.URL.Query()) {
+		for _, v := range vs {
+			r.QueryString = append(r.QueryString, harNameValue{k, v})
+		}
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		contentType := req.Header.Get("Content-Type")
+		r.PostData = &harPostData{MimeType: contentType}
+		switch {
+		case body == nil:
+		case len(body) > h.maxBodyBytes() && rd.parses(contentType):
+			// Only part of the body was read, which cannot be parsed
+			// to replace its secrets
+			r.PostData.Comment = "Body was too large to be redacted"
+		default:
+			// Secrets are replaced before truncating, which could leave
+			// them unparsable
+			size := int64(len(body))
+			if len(body) > h.maxBodyBytes() {
+				size = req.ContentLength
+			}
+			text, comment := h.truncate([]byte(rd.payload(body, contentType)), size)
+			r.PostData.Text, r.PostData.Comment = string(text), comment
+		}
+	}
+	return r
+}
+
+// response returns the HAR rendering of resp, whose body is given unless
+// it was left unread.
+func (h *HARRecorder) response(rd *Redaction, resp *http.Response, body []byte) harResponse {
+	r := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies(), rd.isHeader("Set-Cookie")),
+		Headers:     harHeaders(rd.header(resp.Header)),
+		Content: harContent{
+			Size:     int64(len(body)),
+			MimeType: resp.Header.Get("Content-Type"),
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+	if body == nil {
+		r.Content.Size = -1
+		r.BodySize = -1
+		r.Content.Comment = "Body was streamed"
+		return r
+	}
+	contentType := r.Content.MimeType
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if !utf8.Valid(body) || isBinaryMediaType(mt) {
+		data, comment := h.truncate(body, int64(len(body)))
+		r.Content.Text, r.Content.Comment = base64.StdEncoding.EncodeToString(data), comment
+		r.Content.Encoding = "base64"
+		return r
+	}
+	text, comment := h.truncate([]byte(rd.payload(body, contentType)), int64(len(body)))
+	r.Content.Text, r.Content.Comment = string(text), comment
+	return r
+}
+
+// redaction returns the HARRecorder's Redaction, or DefaultRedaction.
+func (h *HARRecorder) redaction() *Redaction {
+	if h.Redaction != nil {
+		return h.Redaction
+	}
+	return &DefaultRedaction
+}
+
+// maxBodyBytes returns the number of bytes of each body that are recorded,
+// or -1 for none.
+func (h *HARRecorder) maxBodyBytes() int {
+	switch {
+	case h.MaxBodyBytes == 0:
+		return 1 << 20
+	case h.MaxBodyBytes < 0:
+		return -1
+	}
+	return h.MaxBodyBytes
+}
+
+// truncate returns the part of a body that is recorded, along with a
+// comment saying whether it was truncated.  size is the length of the whole
+// body, or -1 if unknown.
+func (h *HARRecorder) truncate(body []byte, size int64) ([]byte, string) {
+	max := h.maxBodyBytes()
+	switch {
+	case max < 0:
+		return nil, "Body was not recorded"
+	case len(body) > max && size > int64(max):
+		return body[:max], fmt.Sprintf("Truncated to %d of %d bytes", max, size)
+	case len(body) > max:
+		return body[:max], fmt.Sprintf("Truncated to %d bytes", max)
+	}
+	return body, ""
+}
+
+// harCookies returns the HAR rendering of cookies, hiding their values if
+// they are secret.
+func harCookies(cookies []*http.Cookie, secret bool) []harNameValue {
+	out := []harNameValue{}
+	for _, c := range cookies {
+		v := c.Value
+		if secret {
+			v = Redacted
+		}
+		out = append(out, harNameValue{c.Name, v})
+	}
+	return out
+}
+
+// harHeaders returns the HAR rendering of header.
+func harHeaders(header http.Header) []harNameValue {
+	out := []harNameValue{}
+	for k, vs := range header {
+		for _, v := range vs {
+			out = append(out, harNameValue{k, v})
+		}
+	}
+	return out
+}
+
+// moduleVersion returns the version of napping built into the program.
+func moduleVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range append([]*debug.Module{&info.Main}, info.Deps...) {
+			if m.Path == "github.com/jmcvetta/napping" && m.Version != "" {
+				return m.Version
+			}
+		}
+	}
+	return "(devel)"
+}
+
+//
+// Timings
+//
+
+// A harTimer collects the timings of an exchange from its client trace.
+type harTimer struct {
+	start   time.Time
+	reqBody []byte // Request body, if it can be read again
+
+	mu                  sync.Mutex // The dialer can trace from other goroutines
+	dnsStart, dnsDone   time.Time
+	connStart, connDone time.Time
+	tlsStart, tlsDone   time.Time
+	gotConn, wroteReq   time.Time
+	firstByte           time.Time
+}
+
+// trace returns a client trace recording into t.
+func (t *harTimer) trace() *httptrace.ClientTrace {
+	at := func(p *time.Time) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		// Only the first connection, request and response are timed, if
+		// redirects are followed.
+		if p.IsZero() {
+			*p = time.Now()
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { at(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { at(&t.dnsDone) },
+		ConnectStart:         func(string, string) { at(&t.connStart) },
+		ConnectDone:          func(string, string, error) { at(&t.connDone) },
+		TLSHandshakeStart:    func() { at(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { at(&t.tlsDone) },
+		GotConn:              func(httptrace.GotConnInfo) { at(&t.gotConn) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { at(&t.wroteReq) },
+		GotFirstResponseByte: func() { at(&t.firstByte) },
+	}
+}
+
+// timings returns the timings of an exchange that ended at end.
+func (t *harTimer) timings(end time.Time) harTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ms := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return -1
+		}
+		return float64(to.Sub(from)) / float64(time.Millisecond)
+	}
+	tm := harTimings{
+		DNS:     ms(t.dnsStart, t.dnsDone),
+		Connect: -1,
+		SSL:     ms(t.tlsStart, t.tlsDone),
+		Send:    ms(t.gotConn, t.wroteReq),
+		Wait:    ms(t.wroteReq, t.firstByte),
+		Receive: ms(t.firstByte, end),
+	}
+	// In HAR, the TLS handshake is part of the connection time
+	if !t.connStart.IsZero() {
+		if t.tlsDone.After(t.connDone) {
+			tm.Connect = ms(t.connStart, t.tlsDone)
+		} else {
+			tm.Connect = ms(t.connStart, t.connDone)
+		}
+	}
+	tm.Blocked = ms(t.start, t.gotConn)
+	for _, d := range []float64{tm.DNS, tm.Connect} {
+		if d > 0 && tm.Blocked >= d {
+			tm.Blocked -= d
+		}
+	}
+	for _, p := range []*float64{&tm.Send, &tm.Wait, &tm.Receive} {
+		if *p < 0 {
+			*p = 0 // Required by HAR
+		}
+	}
+	return tm
+}
+
+//
+// HAR 1.2 document
+//
+
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// total returns the duration of the exchange, in milliseconds.
+func (t harTimings) total() float64 {
+	var total float64
+	for _, d := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if d > 0 {
+			total += d
+		}
+	}
+	return total
+}
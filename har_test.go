@@ -0,0 +1,251 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readHAR decodes the archive written by h.
+func readHAR(t *testing.T, h *HARRecorder) har {
+	buf := &bytes.Buffer{}
+	if _, err := h.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	doc := har{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestHAR(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	s := Session{HAR: &HARRecorder{}}
+	p := Params{"page": "2"}.AsUrlValues()
+	r := Request{
+		Url:     u,
+		Method:  "POST",
+		Params:  &p,
+		Payload: &fooStruct,
+	}
+	_, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := readHAR(t, s.HAR)
+	assert.Equal(t, "1.2", doc.Log.Version)
+	assert.Equal(t, "napping", doc.Log.Creator.Name)
+	if !assert.Equal(t, 1, len(doc.Log.Entries)) {
+		t.FailNow()
+	}
+	e := doc.Log.Entries[0]
+	assert.Equal(t, "POST", e.Request.Method)
+	assert.Equal(t, u+"?page=2", e.Request.URL)
+	assert.Equal(t, "HTTP/1.1", e.Request.HTTPVersion)
+	assert.Equal(t, []harNameValue{{"page", "2"}}, e.Request.QueryString)
+	assert.Contains(t, e.Request.Headers, harNameValue{"Content-Type", "application/json"})
+	if assert.NotNil(t, e.Request.PostData) {
+		assert.Equal(t, "application/json", e.Request.PostData.MimeType)
+		assert.Equal(t, `{"Foo":111,"Bar":"foo"}`, e.Request.PostData.Text)
+	}
+	assert.Equal(t, int64(23), e.Request.BodySize)
+	assert.Equal(t, 200, e.Response.Status)
+	assert.Equal(t, "OK", e.Response.StatusText)
+	assert.Equal(t, `{"Foo":222,"Bar":"bar"}`, e.Response.Content.Text)
+	assert.Equal(t, int64(23), e.Response.Content.Size)
+	assert.True(t, e.Timings.Wait >= 0)
+	assert.True(t, e.Timings.Connect >= 0)
+	assert.Equal(t, -1.0, e.Timings.SSL)
+	assert.True(t, e.Time > 0)
+}
+
+func TestHARBounds(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(flakyHandler(2, http.StatusServiceUnavailable, &calls))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	policy := fastRetry
+	s := Session{
+		Retry: &policy,
+		HAR:   &HARRecorder{MaxEntries: 2, MaxBodyBytes: 10},
+	}
+	//
+	// Every attempt is recorded, but only the last entries are kept
+	//
+	_, err := s.Put(u, &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := readHAR(t, s.HAR)
+	if !assert.Equal(t, 2, len(doc.Log.Entries)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "1 earlier entries were dropped", doc.Log.Comment)
+	assert.Equal(t, 503, doc.Log.Entries[0].Response.Status)
+	e := doc.Log.Entries[1]
+	assert.Equal(t, 200, e.Response.Status)
+	assert.Equal(t, `{"Foo":111`, e.Response.Content.Text)
+	assert.Equal(t, "Truncated to 10 of 23 bytes", e.Response.Content.Comment)
+	assert.Equal(t, `{"Foo":111`, e.Request.PostData.Text)
+	//
+	// Pausing
+	//
+	s.HAR.Reset()
+	s.HAR.Pause()
+	assert.False(t, s.HAR.Recording())
+	s.Post(u, &fooStruct, nil, nil)
+	assert.Equal(t, 0, s.HAR.Len())
+	s.HAR.Resume()
+	s.Post(u, &fooStruct, nil, nil)
+	assert.Equal(t, 1, s.HAR.Len())
+	//
+	// Transport failures
+	//
+	s.HAR.Reset()
+	s.Retry = nil
+	_, err = s.Get("http://127.0.0.1:1", nil, nil, nil)
+	assert.NotNil(t, err)
+	doc = readHAR(t, s.HAR)
+	if assert.Equal(t, 1, len(doc.Log.Entries)) {
+		assert.Equal(t, 0, doc.Log.Entries[0].Response.Status)
+		assert.Contains(t, doc.Log.Entries[0].Comment, "refused")
+	}
+}
+
+func TestHARRedaction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "hunter2"})
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte{0xff, 0x00})
+	}))
+	defer srv.Close()
+	rd := DefaultRedaction
+	rd.Params = []string{"api_key"}
+	s := Session{
+		Header: &http.Header{"Authorization": {"Bearer hunter2"}},
+		HAR:    &HARRecorder{Redaction: &rd},
+	}
+	p := Params{"api_key": "hunter2"}.AsUrlValues()
+	_, err := s.Get("http://"+srv.Listener.Addr().String(), &p, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "session.har")
+	if err = s.HAR.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(blob), "hunter2")
+	doc := readHAR(t, s.HAR)
+	e := doc.Log.Entries[0]
+	assert.Contains(t, e.Request.Headers, harNameValue{"Authorization", Redacted})
+	assert.Equal(t, []harNameValue{{"api_key", Redacted}}, e.Request.QueryString)
+	assert.Equal(t, []harNameValue{{"session", Redacted}}, e.Response.Cookies)
+	assert.Equal(t, "base64", e.Response.Content.Encoding)
+	assert.Equal(t, "/wA=", e.Response.Content.Text)
+	//
+	// Transport failures
+	//
+	s.HAR.Reset()
+	_, err = s.Get("http://127.0.0.1:1/x", &p, nil, nil)
+	assert.NotNil(t, err)
+	e = readHAR(t, s.HAR).Log.Entries[0]
+	assert.Contains(t, e.Comment, "api_key="+Redacted)
+	assert.Contains(t, e.Comment, "refused")
+	assert.NotContains(t, e.Comment, "hunter2")
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func TestHARRequestBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleLengthEcho))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	//
+	// Request bodies are read no further than recorded
+	//
+	var reads []*int64
+	var mu sync.Mutex
+	payload := strings.Repeat("a", 1000)
+	r := Request{
+		Url:        u,
+		Method:     "PUT",
+		RawPayload: true,
+		Header:     &http.Header{"Authorization": {"Bearer hunter2"}},
+		GetBody: func() (io.ReadCloser, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			n := new(int64)
+			reads = append(reads, n)
+			return ioutil.NopCloser(countingReader{strings.NewReader(payload), n}), nil
+		},
+		ContentLength: 1000,
+	}
+	s := Session{HAR: &HARRecorder{MaxBodyBytes: 10}}
+	if _, err := s.Send(&r); err != nil {
+		t.Fatal(err)
+	}
+	counts := []int64{}
+	for _, n := range reads {
+		counts = append(counts, *n)
+	}
+	assert.ElementsMatch(t, []int64{1000, 11}, counts)
+	e := readHAR(t, s.HAR).Log.Entries[0]
+	assert.Equal(t, "aaaaaaaaaa", e.Request.PostData.Text)
+	assert.Equal(t, "Truncated to 10 of 1000 bytes", e.Request.PostData.Comment)
+	//
+	// Secrets are redacted by default
+	//
+	assert.Contains(t, e.Request.Headers, harNameValue{"Authorization", Redacted})
+	//
+	// Unless bodies can be parsed, secrets in them cannot be redacted
+	//
+	rd := Redaction{Fields: []string{"password"}}
+	s.HAR = &HARRecorder{MaxBodyBytes: 10, Redaction: &rd}
+	if _, err := s.Put(u, &fooStruct, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	e = readHAR(t, s.HAR).Log.Entries[0]
+	assert.Equal(t, "", e.Request.PostData.Text)
+	assert.Equal(t, "Body was too large to be redacted", e.Request.PostData.Comment)
+	//
+	// Nor read at all when no body is recorded
+	//
+	reads = nil
+	s.HAR = &HARRecorder{MaxBodyBytes: -1}
+	if _, err := s.Send(&r); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, reads, 1)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements structured logging of requests and responses.
+*/
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A Logger receives structured log events from a Session.  Args are
+// alternating keys and values, as for slog.Logger.Log, so a *slog.Logger can
+// be used directly.
+//
+// Every attempt to send a request is logged as a "request" event at
+// LevelDebug, and its outcome as a "response" event at LevelInfo, or a
+// "request failed" event at LevelError.  Retries are logged as "retry"
+// events at LevelWarn.  Events carry the method, url, attempt and, where
+// known, status, duration, request_bytes and response_bytes.  Secret query
+// parameters are redacted from the url according to the Session's Redaction.
+type Logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...interface{})
+}
+
+// logEvent sends an event to the Session's Logger, if it has one.
+func (s *Session) logEvent(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Log(ctx, level, msg, args...)
+	}
+}
+
+// logAttempt logs an attempt to send req.
+func (s *Session) logAttempt(ctx context.Context, req *http.Request, attempt int) {
+	s.logEvent(ctx, slog.LevelDebug, "request",
+		"method", req.Method,
+		"url", s.redaction().url(req.URL),
+		"attempt", attempt,
+		"request_bytes", req.ContentLength,
+	)
+}
+
+// logOutcome logs the outcome of an attempt to send req, which took d.
+func (s *Session) logOutcome(ctx context.Context, req *http.Request, attempt int, d time.Duration, rsp *Response, err error) {
+	if err != nil {
+		s.logEvent(ctx, slog.LevelError, "request failed",
+			"method", req.Method,
+			"url", s.redaction().url(req.URL),
+			"attempt", attempt,
+			"duration", d,
+			"error", s.redaction().err(err),
+		)
+		return
+	}
+	size := int64(len(rsp.body))
+	if rsp.Stream {
+		size = rsp.response.ContentLength
+	}
+	s.logEvent(ctx, slog.LevelInfo, "response",
+		"method", req.Method,
+		"url", s.redaction().url(req.URL),
+		"attempt", attempt,
+		"status", rsp.response.StatusCode,
+		"duration", d,
+		"request_bytes", req.ContentLength,
+		"response_bytes", size,
+	)
+}
+
+// logRetry logs that req will be retried after delay.
+func (s *Session) logRetry(ctx context.Context, req *http.Request, attempt int, delay time.Duration) {
+	s.logEvent(ctx, slog.LevelWarn, "retry",
+		"method", req.Method,
+		"url", s.redaction().url(req.URL),
+		"attempt", attempt,
+		"delay", delay,
+	)
+}
+
+// logDebug writes a line of the debug dump enabled by Session.Log to the
+// Session's Logger at LevelDebug, if any.  Session.log falls back to the
+// standard logger.
+func (s *Session) logDebug(args ...interface{}) {
+	if s.Logger == nil {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+	s.Logger.Log(context.Background(), slog.LevelDebug, msg)
+}
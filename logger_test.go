@@ -0,0 +1,138 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// jsonLogger returns a Logger writing JSON events at level and above to buf.
+func jsonLogger(buf *bytes.Buffer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: level}))
+}
+
+// events parses the JSON events written to buf.
+func events(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	evs := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		ev := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestLogger(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	buf := &bytes.Buffer{}
+	s := Session{Logger: jsonLogger(buf, slog.LevelDebug)}
+	u := "http://" + srv.Listener.Addr().String()
+	res := structType{}
+	_, err := s.Post(u, &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evs := events(t, buf)
+	if !assert.Equal(t, 2, len(evs)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "DEBUG", evs[0]["level"])
+	assert.Equal(t, "request", evs[0]["msg"])
+	assert.Equal(t, "POST", evs[0]["method"])
+	assert.Equal(t, u, evs[0]["url"])
+	assert.Equal(t, 1.0, evs[0]["attempt"])
+	assert.Equal(t, 23.0, evs[0]["request_bytes"])
+	assert.Equal(t, "INFO", evs[1]["level"])
+	assert.Equal(t, "response", evs[1]["msg"])
+	assert.Equal(t, 200.0, evs[1]["status"])
+	assert.Equal(t, 23.0, evs[1]["response_bytes"])
+	assert.Contains(t, evs[1], "duration")
+}
+
+func TestLoggerLevels(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(flakyHandler(1, http.StatusServiceUnavailable, &calls))
+	defer srv.Close()
+	buf := &bytes.Buffer{}
+	policy := fastRetry
+	s := Session{
+		Logger: jsonLogger(buf, slog.LevelInfo),
+		Retry:  &policy,
+	}
+	_, err := s.Get("http://"+srv.Listener.Addr().String(), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []interface{}{}
+	for _, ev := range events(t, buf) {
+		msgs = append(msgs, ev["msg"])
+	}
+	assert.Equal(t, []interface{}{"response", "retry", "response"}, msgs)
+	//
+	// Transport failures
+	//
+	buf.Reset()
+	_, err = s.Get("foo://bar.com", nil, nil, nil)
+	assert.NotNil(t, err)
+	evs := events(t, buf)
+	if assert.True(t, len(evs) > 0) {
+		assert.Equal(t, "ERROR", evs[0]["level"])
+		assert.Equal(t, "request failed", evs[0]["msg"])
+		assert.Contains(t, evs[0]["error"], "unsupported protocol scheme")
+	}
+}
+
+func TestLoggerDebugDump(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(HandlePost))
+	defer srv.Close()
+	buf := &bytes.Buffer{}
+	s := Session{
+		Log:    true,
+		Logger: jsonLogger(buf, slog.LevelDebug),
+	}
+	_, err := s.Post("http://"+srv.Listener.Addr().String(), &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, buf.String(), `"msg":"REQUEST"`)
+	assert.Contains(t, buf.String(), `"msg":"RESPONSE"`)
+}
+
+func TestLoggerRedactsErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := Session{
+		Log:       true,
+		Logger:    jsonLogger(buf, slog.LevelDebug),
+		Redaction: &Redaction{Params: []string{"api_key"}},
+	}
+	p := Params{"api_key": "hunter2"}.AsUrlValues()
+	_, err := s.Get("http://127.0.0.1:1/x", &p, nil, nil)
+	assert.NotNil(t, err)
+	assert.NotContains(t, buf.String(), "hunter2")
+	failed := 0
+	for _, ev := range events(t, buf) {
+		if ev["msg"] == "request failed" {
+			failed++
+			assert.Contains(t, ev["error"], "api_key="+Redacted)
+			assert.Contains(t, ev["error"], "refused")
+		}
+	}
+	assert.Equal(t, 1, failed)
+}
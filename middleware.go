@@ -0,0 +1,59 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the middleware chain wrapped around each attempt to
+send a request.
+*/
+
+import (
+	"io/ioutil"
+	"net/http"
+)
+
+// A Handler sends a fully built HTTP request and returns the server's
+// response, with its body already read.
+type Handler func(req *http.Request) (*Response, error)
+
+// A Middleware wraps a Handler to add behavior around it.  It may inspect or
+// mutate req before calling next, inspect or mutate the Response after next
+// returns, call next more than once, or not call it at all and return a
+// Response of its own.
+//
+// Middleware runs once per attempt.  By the time it sees req, the Session and
+// Request parameters, headers and credentials have been merged and the
+// payload has been encoded.  Result and Error are unmarshaled only after the
+// whole chain has returned, and the Session's retry policy is applied outside
+// of it.  A Middleware that sends req again must first reset req.Body from
+// req.GetBody.
+type Middleware func(next Handler) Handler
+
+// chain wraps h with the Session's middleware.  The first Middleware in
+// s.Middleware is the outermost, and so sees the request first and the
+// response last.
+func (s *Session) chain(h Handler) Handler {
+	for i := len(s.Middleware) - 1; i >= 0; i-- {
+		h = s.Middleware[i](h)
+	}
+	return h
+}
+
+// NewResponse reads and closes the body of resp and returns it as a Response,
+// e.g. for a Middleware that answers requests without calling the next
+// Handler.
+func NewResponse(resp *http.Response) (*Response, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		status:   resp.StatusCode,
+		response: resp,
+		body:     body,
+	}, nil
+}
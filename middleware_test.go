@@ -0,0 +1,124 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder returns a Middleware that appends name to calls on the way in and
+// on the way out.
+func recorder(name string, calls *[]string) Middleware {
+	return func(next Handler) Handler {
+		return func(req *http.Request) (*Response, error) {
+			*calls = append(*calls, ">"+name)
+			req.Header.Add("X-Middleware", name)
+			resp, err := next(req)
+			*calls = append(*calls, "<"+name)
+			return resp, err
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		HandlePost(w, req)
+	}))
+	defer srv.Close()
+	calls := []string{}
+	s := Session{
+		Middleware: []Middleware{
+			recorder("outer", &calls),
+			recorder("inner", &calls),
+		},
+	}
+	res := structType{}
+	resp, err := s.Post("http://"+srv.Listener.Addr().String(), &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, barStruct, res)
+	assert.Equal(t, []string{">outer", ">inner", "<inner", "<outer"}, calls)
+	assert.Equal(t, []string{"outer", "inner"}, header["X-Middleware"])
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	s := Session{
+		Middleware: []Middleware{
+			func(next Handler) Handler {
+				return func(req *http.Request) (*Response, error) {
+					return NewResponse(&http.Response{
+						StatusCode: 200,
+						Header:     http.Header{},
+						Body:       ioutil.NopCloser(bytes.NewBufferString(`{"Foo": 222, "Bar": "bar"}`)),
+						Request:    req,
+					})
+				}
+			},
+		},
+	}
+	res := structType{}
+	resp, err := s.Get("http://napping.invalid/", nil, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, barStruct, res)
+}
+
+func TestMiddlewareResend(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(flakyHandler(1, http.StatusServiceUnavailable, &calls))
+	defer srv.Close()
+	resend := func(next Handler) Handler {
+		return func(req *http.Request) (*Response, error) {
+			resp, err := next(req)
+			if err != nil || resp.Status() != http.StatusServiceUnavailable {
+				return resp, err
+			}
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return next(req)
+		}
+	}
+	s := Session{Middleware: []Middleware{resend}}
+	res := structType{}
+	resp, err := s.Post("http://"+srv.Listener.Addr().String(), &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, 1, resp.Attempts())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, fooStruct, res)
+}
+
+func TestMiddlewareNoResponse(t *testing.T) {
+	s := Session{
+		Middleware: []Middleware{
+			func(next Handler) Handler {
+				return func(req *http.Request) (*Response, error) {
+					return nil, nil
+				}
+			},
+		},
+	}
+	_, err := s.Get("http://napping.invalid/", nil, nil, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements multipart/form-data payloads.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Multipart is a multipart/form-data payload made of form fields and
+// files.  Use a *Multipart as the Payload of a Request, e.g. with
+// Session.Post, to upload it.  The body is streamed to the server as it is
+// written, so files are never loaded into memory as a whole.
+type Multipart struct {
+	Fields url.Values
+	Files  []FormFile
+
+	boundary string
+}
+
+// A FormFile is a file uploaded in a Multipart payload.  Its content is read
+// from exactly one of Path, Reader or Data.
+type FormFile struct {
+	Field       string // Name of the form field
+	Filename    string // Defaults to the base name of Path
+	ContentType string // Defaults to application/octet-stream
+
+	Path   string    // Read the file from disk
+	Reader io.Reader // Read the file from a stream, which can only be sent once
+	Data   []byte    // Send the file from memory
+}
+
+// quoteEscaper escapes quoted strings in Content-Disposition headers, as
+// mime/multipart does.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// ContentType returns the Content-Type header of the payload, including its
+// boundary.
+func (m *Multipart) ContentType() string {
+	if m.boundary == "" {
+		m.boundary = multipart.NewWriter(nil).Boundary()
+	}
+	return "multipart/form-data; boundary=" + m.boundary
+}
+
+// newBody returns a body producing the encoded payload.  It can be replayed
+// unless one of the files is read from a Reader.
+func (m *Multipart) newBody() *body {
+	m.ContentType()
+	b := &body{
+		getBody: m.open,
+		length:  m.length(),
+	}
+	for _, f := range m.Files {
+		if f.Reader != nil {
+			b.once = true
+		}
+	}
+	return b
+}
+
+// open starts writing the encoded payload to a pipe and returns its reading
+// end.  Closing the reader stops the writer.
+func (m *Multipart) open() (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(m.write(pw, false))
+	}()
+	return pr, nil
+}
+
+// length returns the length of the encoded payload, or -1 if the size of one
+// of the files is unknown.
+func (m *Multipart) length() int64 {
+	var n int64
+	for _, f := range m.Files {
+		switch {
+		case f.Path != "":
+			fi, err := os.Stat(f.Path)
+			if err != nil {
+				return -1
+			}
+			n += fi.Size()
+		case f.Reader != nil:
+			return -1
+		default:
+			n += int64(len(f.Data))
+		}
+	}
+	c := &countingWriter{}
+	if m.write(c, true) != nil {
+		return -1
+	}
+	return n + c.n
+}
+
+// write encodes the payload to w.  If skipContent is true, the content of the
+// files is left out.
+func (m *Multipart) write(w io.Writer, skipContent bool) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m.Fields))
+	for k := range m.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range m.Fields[k] {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range m.Files {
+		filename := f.Filename
+		if filename == "" && f.Path != "" {
+			filename = filepath.Base(f.Path)
+		}
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			quoteEscaper.Replace(f.Field), quoteEscaper.Replace(filename)))
+		h.Set("Content-Type", contentType)
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if skipContent {
+			continue
+		}
+		if err = f.copy(pw); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// copy writes the content of the file to w.
+func (f *FormFile) copy(w io.Writer) error {
+	var r io.Reader
+	switch {
+	case f.Path != "":
+		file, err := os.Open(f.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	case f.Reader != nil:
+		r = f.Reader
+	default:
+		r = bytes.NewReader(f.Data)
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// countingWriter counts the bytes written to it and discards them.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return ioutil.Discard.Write(p)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type uploadedFile struct {
+	Filename    string
+	ContentType string
+	Content     string
+}
+
+type upload struct {
+	Length int64
+	Fields url.Values
+	Files  map[string]uploadedFile
+}
+
+// handleUpload parses a multipart/form-data request and answers with an
+// upload describing it.
+func handleUpload(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res := upload{
+		Length: req.ContentLength,
+		Fields: url.Values(req.MultipartForm.Value),
+		Files:  map[string]uploadedFile{},
+	}
+	for field, headers := range req.MultipartForm.File {
+		for _, h := range headers {
+			f, _ := h.Open()
+			b, _ := ioutil.ReadAll(f)
+			f.Close()
+			res.Files[field] = uploadedFile{h.Filename, h.Header.Get("Content-Type"), string(b)}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func TestMultipart(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleUpload))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := ioutil.WriteFile(path, []byte("from disk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &Multipart{
+		Fields: url.Values{"name": {"napping"}, "tags": {"go", "http"}},
+		Files: []FormFile{
+			{Field: "disk", Path: path, ContentType: "text/plain"},
+			{Field: "memory", Filename: "data.bin", Data: []byte{0, 1, 2}},
+		},
+	}
+	res := upload{}
+	resp, err := Post("http://"+srv.Listener.Addr().String(), m, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.True(t, res.Length > 0)
+	assert.Equal(t, m.Fields, res.Fields)
+	assert.Equal(t, uploadedFile{"notes.txt", "text/plain", "from disk"}, res.Files["disk"])
+	assert.Equal(t, uploadedFile{"data.bin", "application/octet-stream", "\x00\x01\x02"}, res.Files["memory"])
+}
+
+func TestMultipartReader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleUpload))
+	defer srv.Close()
+	m := &Multipart{
+		Files: []FormFile{
+			{Field: "stream", Filename: `we"ird.txt`, Reader: strings.NewReader("from a reader")},
+		},
+	}
+	res := upload{}
+	s := Session{}
+	resp, err := s.Put("http://"+srv.Listener.Addr().String(), m, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, int64(-1), res.Length)
+	assert.Equal(t, uploadedFile{`we"ird.txt`, "application/octet-stream", "from a reader"}, res.Files["stream"])
+}
+
+func TestMultipartRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handleUpload(w, req)
+	}))
+	defer srv.Close()
+	url := "http://" + srv.Listener.Addr().String()
+	policy := fastRetry
+	s := Session{Retry: &policy}
+	m := &Multipart{
+		Files: []FormFile{{Field: "memory", Filename: "a.txt", Data: []byte("again")}},
+	}
+	res := upload{}
+	resp, err := s.Put(url, m, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, resp.Attempts())
+	assert.Equal(t, "again", res.Files["memory"].Content)
+	//
+	// Files read from a Reader can only be sent once
+	//
+	atomic.StoreInt32(&calls, 0)
+	m = &Multipart{
+		Files: []FormFile{{Field: "stream", Filename: "a.txt", Reader: strings.NewReader("once")}},
+	}
+	resp, err = s.Put(url, m, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 503, resp.Status())
+	assert.Equal(t, 1, resp.Attempts())
+}
+
+func TestMultipartMissingFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleUpload))
+	defer srv.Close()
+	m := &Multipart{
+		Files: []FormFile{{Field: "disk", Path: filepath.Join(os.TempDir(), "napping-does-not-exist")}},
+	}
+	_, err := Post("http://"+srv.Listener.Addr().String(), m, nil, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,338 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+/*
+Package nappingtest provides a scriptable fake server for testing clients of
+RESTful APIs.
+
+Expected requests are declared along with their canned JSON responses, and
+the server reports every request that does not match, with a diff:
+
+	func TestClient(t *testing.T) {
+		srv := nappingtest.NewServer(t)
+		srv.Expect("POST", "/users").
+			Header("Authorization", "Bearer token").
+			JSON(User{Name: "jtkirk"}).
+			Respond(201, User{ID: 1, Name: "jtkirk"})
+		res := User{}
+		napping.Post(srv.URL+"/users", &User{Name: "jtkirk"}, &res, nil)
+		...
+	}
+
+When the test ends, the server is closed and every expectation that was not
+met is reported.
+*/
+package nappingtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TB is the part of testing.TB used by Server.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// A Server is an httptest.Server answering the requests it expects.
+type Server struct {
+	*httptest.Server
+
+	t            TB
+	mu           sync.Mutex
+	expectations []*Expectation
+}
+
+// NewServer starts a Server reporting to t.  It is closed and verified when
+// the test ends.
+func NewServer(t TB) *Server {
+	s := &Server{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(func() {
+		s.Close()
+		s.Verify()
+	})
+	return s
+}
+
+// Expect declares that a request with the given method and path is
+// expected, once unless Times says otherwise.  It answers 200 with an empty
+// body unless Respond says otherwise.
+func (s *Server) Expect(method, path string) *Expectation {
+	e := &Expectation{
+		method: strings.ToUpper(method),
+		path:   path,
+		header: http.Header{},
+		times:  1,
+		status: http.StatusOK,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expectations = append(s.expectations, e)
+	return e
+}
+
+// Verify reports every expectation that was not met.  It returns false if
+// there was any.
+func (s *Server) Verify() bool {
+	s.t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := true
+	for _, e := range s.expectations {
+		if e.calls < e.times {
+			s.t.Errorf("nappingtest: expected %s, received %d of %d", e, e.calls, e.times)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// serve answers req with the response of the expectation it matches, or
+// reports why it does not match any.
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	s.mu.Lock()
+	var candidate *Expectation
+	var diffs []string
+	for _, e := range s.expectations {
+		if e.method != req.Method || e.path != req.URL.Path || e.calls >= e.times {
+			continue
+		}
+		d := e.diff(req, body)
+		if len(d) == 0 {
+			e.calls++
+			s.mu.Unlock()
+			e.respond(w)
+			return
+		}
+		if candidate == nil {
+			candidate, diffs = e, d
+		}
+	}
+	s.mu.Unlock()
+	var msg string
+	if candidate == nil {
+		msg = fmt.Sprintf("nappingtest: unexpected request %s %s", req.Method, req.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		msg = fmt.Sprintf("nappingtest: request does not match %s:\n\t%s", candidate, strings.Join(diffs, "\n\t"))
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	s.t.Errorf("%s", msg)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// An Expectation describes a request the Server expects, and its response.
+type Expectation struct {
+	method  string
+	path    string
+	params  url.Values
+	header  http.Header
+	payload interface{}
+	hasJSON bool
+	times   int
+	calls   int
+
+	status     int
+	respHeader http.Header
+	response   interface{}
+}
+
+// Params sets query parameters the request must have.  Other parameters are
+// ignored.
+func (e *Expectation) Params(p url.Values) *Expectation {
+	e.params = p
+	return e
+}
+
+// Header sets a header the request must have.  Other headers are ignored.
+func (e *Expectation) Header(key, value string) *Expectation {
+	e.header.Add(key, value)
+	return e
+}
+
+// JSON sets the payload the request must have.  It is compared as decoded
+// JSON, so formatting and key order do not matter.
+func (e *Expectation) JSON(v interface{}) *Expectation {
+	e.payload = v
+	e.hasJSON = true
+	return e
+}
+
+// Times sets the number of matching requests expected.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Respond sets the status of the response, and its body, which is encoded
+// as JSON unless it is nil.
+func (e *Expectation) Respond(status int, body interface{}) *Expectation {
+	e.status = status
+	e.response = body
+	return e
+}
+
+// RespondHeader sets a header of the response.
+func (e *Expectation) RespondHeader(key, value string) *Expectation {
+	if e.respHeader == nil {
+		e.respHeader = http.Header{}
+	}
+	e.respHeader.Add(key, value)
+	return e
+}
+
+// String describes the expected request.
+func (e *Expectation) String() string {
+	s := e.method + " " + e.path
+	if len(e.params) > 0 {
+		s += "?" + e.params.Encode()
+	}
+	return s
+}
+
+// respond writes the canned response.
+func (e *Expectation) respond(w http.ResponseWriter) {
+	for k, vs := range e.respHeader {
+		w.Header()[k] = vs
+	}
+	if e.response == nil {
+		w.WriteHeader(e.status)
+		return
+	}
+	blob, err := json.Marshal(e.response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(e.status)
+	w.Write(blob)
+}
+
+// diff describes how req, whose body has been read, differs from the
+// expected request.
+func (e *Expectation) diff(req *http.Request, body []byte) []string {
+	var diffs []string
+	q := req.URL.Query()
+	for _, k := range sortedKeys(e.params) {
+		if !reflect.DeepEqual(e.params[k], q[k]) {
+			diffs = append(diffs, fmt.Sprintf("param %s: got %q, want %q", k, q[k], e.params[k]))
+		}
+	}
+	for _, k := range sortedKeys(e.header) {
+		if got := req.Header.Values(k); !reflect.DeepEqual(e.header[k], got) {
+			diffs = append(diffs, fmt.Sprintf("header %s: got %q, want %q", k, got, e.header[k]))
+		}
+	}
+	if !e.hasJSON {
+		return diffs
+	}
+	if ct := req.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		diffs = append(diffs, fmt.Sprintf("Content-Type: got %q, want %q", ct, "application/json"))
+	}
+	want, err := normalize(e.payload)
+	if err != nil {
+		return append(diffs, fmt.Sprintf("payload: cannot encode expected payload: %v", err))
+	}
+	var got interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err = d.Decode(&got); err != nil {
+		return append(diffs, fmt.Sprintf("payload: not JSON (%v): %q", err, body))
+	}
+	return append(diffs, diffJSON("payload", got, want)...)
+}
+
+// normalize returns v as it decodes from JSON.
+func normalize(v interface{}) (interface{}, error) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var n interface{}
+	d := json.NewDecoder(bytes.NewReader(blob))
+	d.UseNumber()
+	err = d.Decode(&n)
+	return n, err
+}
+
+// diffJSON describes the differences between two decoded JSON values found
+// at path.
+func diffJSON(path string, got, want interface{}) []string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for k := range w {
+			keys[k] = true
+		}
+		for k := range g {
+			keys[k] = true
+		}
+		var diffs []string
+		for _, k := range sortedKeys(keys) {
+			gv, gok := g[k]
+			wv, wok := w[k]
+			switch {
+			case !gok:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: missing, want %s", path, k, render(wv)))
+			case !wok:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: got %s, want nothing", path, k, render(gv)))
+			default:
+				diffs = append(diffs, diffJSON(path+"."+k, gv, wv)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			break
+		}
+		var diffs []string
+		for i := range w {
+			diffs = append(diffs, diffJSON(fmt.Sprintf("%s[%d]", path, i), g[i], w[i])...)
+		}
+		return diffs
+	}
+	if reflect.DeepEqual(got, want) {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: got %s, want %s", path, render(got), render(want))}
+}
+
+// render returns v as compact JSON.
+func render(v interface{}) string {
+	blob, _ := json.Marshal(v)
+	return string(blob)
+}
+
+// sortedKeys returns the keys of a map with string keys, in order.
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	keys := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
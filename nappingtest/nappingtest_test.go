@@ -0,0 +1,109 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package nappingtest_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jmcvetta/napping"
+	"github.com/jmcvetta/napping/nappingtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	ID    int      `json:"id,omitempty"`
+	Name  string   `json:"name"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// recorder is a nappingtest.TB collecting the reported errors.
+type recorder struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+// end runs the cleanups, as at the end of a test.
+func (r *recorder) end() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestServer(t *testing.T) {
+	srv := nappingtest.NewServer(t)
+	srv.Expect("POST", "/users").
+		Header("Authorization", "Bearer token").
+		JSON(user{Name: "jtkirk", Roles: []string{"captain"}}).
+		Respond(http.StatusCreated, user{ID: 1, Name: "jtkirk"}).
+		RespondHeader("Location", "/users/1")
+	srv.Expect("GET", "/users").
+		Params(url.Values{"page": {"2"}}).
+		Respond(http.StatusOK, []user{{ID: 1, Name: "jtkirk"}}).
+		Times(2)
+	s := napping.Session{Header: &http.Header{"Authorization": {"Bearer token"}}}
+	created := user{}
+	resp, err := s.Post(srv.URL+"/users", &user{Name: "jtkirk", Roles: []string{"captain"}}, &created, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 201, resp.Status())
+	assert.Equal(t, "/users/1", resp.HttpResponse().Header.Get("Location"))
+	assert.Equal(t, user{ID: 1, Name: "jtkirk"}, created)
+	p := url.Values{"page": {"2"}, "sort": {"name"}}
+	for i := 0; i < 2; i++ {
+		users := []user{}
+		resp, err = s.Get(srv.URL+"/users", &p, &users, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, 200, resp.Status())
+		assert.Equal(t, []user{{ID: 1, Name: "jtkirk"}}, users)
+	}
+	assert.True(t, srv.Verify())
+}
+
+func TestServerMismatch(t *testing.T) {
+	rec := &recorder{}
+	srv := nappingtest.NewServer(rec)
+	srv.Expect("PUT", "/users/1").
+		Header("X-Version", "2").
+		JSON(user{Name: "jtkirk", Roles: []string{"captain", "author"}})
+	srv.Expect("DELETE", "/users/1")
+	resp, err := napping.Put(srv.URL+"/users/1", &user{Name: "spock", Roles: []string{"captain"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 400, resp.Status())
+	resp, err = napping.Get(srv.URL+"/users/2", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 404, resp.Status())
+	rec.end()
+	if !assert.Equal(t, 4, len(rec.errors)) {
+		t.FailNow()
+	}
+	assert.Equal(t, "nappingtest: request does not match PUT /users/1:\n"+
+		"\theader X-Version: got [], want [\"2\"]\n"+
+		"\tpayload.name: got \"spock\", want \"jtkirk\"\n"+
+		"\tpayload.roles: got [\"captain\"], want [\"captain\",\"author\"]", rec.errors[0])
+	assert.Equal(t, "nappingtest: unexpected request GET /users/2", rec.errors[1])
+	assert.Equal(t, "nappingtest: expected PUT /users/1, received 0 of 1", rec.errors[2])
+	assert.Equal(t, "nappingtest: expected DELETE /users/1, received 0 of 1", rec.errors[3])
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		match   bool
+	}{
+		{"example.com", "http://example.com/", true},
+		{"example.com", "http://api.EXAMPLE.com./", true},
+		{"example.com", "http://notexample.com/", false},
+		{".example.com", "http://example.com/", false},
+		{".example.com", "http://api.example.com/", true},
+		{"*", "http://example.com/", true},
+		{"example.com:8080", "http://example.com:8080/", true},
+		{"example.com:443", "https://example.com/", true},
+		{"example.com:443", "http://example.com/", false},
+		{"10.0.0.0/8", "http://10.1.2.3/", true},
+		{"10.0.0.0/8", "http://11.1.2.3/", false},
+		{"::1", "http://[::1]:80/", true},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		assert.Equal(t, test.match, proxyMatch(test.pattern, u) >= 0, test.pattern+" "+test.url)
+	}
+}
+
+func TestProxySelection(t *testing.T) {
+	p := &Proxy{
+		URL: "http://proxy:3128",
+		Hosts: map[string]string{
+			"example.com":      "socks5://socks:1080",
+			"api.example.com":  "",
+			"*":                "http://other:3128",
+			"10.0.0.0/8":       "http://internal:3128",
+			"bad.example.com":  "ftp://proxy",
+			"ugly.example.com": "::",
+		},
+		NoProxy: []string{"localhost", ".internal"},
+	}
+	get := func(rawurl string) string {
+		req, _ := http.NewRequest("GET", rawurl, nil)
+		u, err := p.proxy(req)
+		if err != nil {
+			return err.Error()
+		}
+		if u == nil {
+			return "direct"
+		}
+		return u.String()
+	}
+	assert.Equal(t, "socks5://socks:1080", get("http://www.example.com/"))
+	assert.Equal(t, "direct", get("http://api.example.com/"))
+	assert.Equal(t, "http://other:3128", get("http://example.org/"))
+	assert.Equal(t, "http://internal:3128", get("http://10.0.0.1/"))
+	assert.Equal(t, "direct", get("http://localhost:8080/"))
+	assert.Equal(t, "direct", get("http://db.internal/"))
+	assert.Equal(t, `napping: unsupported proxy scheme "ftp"`, get("http://bad.example.com/"))
+	assert.Equal(t, `napping: invalid proxy URL "::"`, get("http://ugly.example.com/"))
+	p.Hosts = nil
+	assert.Equal(t, "http://proxy:3128", get("http://example.org/"))
+	//
+	// Environment
+	//
+	t.Setenv("HTTP_PROXY", "http://env:3128")
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("NO_PROXY", "example.com")
+	p = ProxyFromEnvironment()
+	assert.Equal(t, "http://env:3128", get("http://example.org/"))
+	assert.Equal(t, "direct", get("https://example.org/"))
+	assert.Equal(t, "direct", get("http://api.example.com/"))
+	p.NoProxy = []string{"example.org"}
+	assert.Equal(t, "direct", get("http://example.org/"))
+}
+
+func TestHTTPProxy(t *testing.T) {
+	var requestURI, auth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestURI = req.RequestURI
+		auth = req.Header.Get("Proxy-Authorization")
+		w.Write([]byte(`{"Foo": 111, "Bar": "proxied"}`))
+	}))
+	defer proxy.Close()
+	s := Session{Proxy: &Proxy{URL: "http://jtkirk:hunter2@" + proxy.Listener.Addr().String()}}
+	res := structType{}
+	resp, err := s.Get("http://example.test/a?b=c", nil, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, "proxied", res.Bar)
+	assert.Equal(t, "http://example.test/a?b=c", requestURI)
+	assert.Equal(t, "Basic anRraXJrOmh1bnRlcjI=", auth)
+}
+
+// socksServer is a SOCKS5 proxy that connects every client to backend, and
+// records the addresses requested.
+type socksServer struct {
+	net.Listener
+	backend  string
+	user     string
+	password string
+
+	mu        sync.Mutex
+	addresses []string
+}
+
+func newSocksServer(t *testing.T, backend, user, password string) *socksServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socksServer{Listener: l, backend: backend, user: user, password: password}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) serve(c net.Conn) {
+	defer c.Close()
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	//
+	// Method negotiation, and username/password authentication (RFC 1929)
+	//
+	head := read(2)
+	if head == nil || read(int(head[1])) == nil {
+		return
+	}
+	if s.user == "" {
+		c.Write([]byte{5, 0})
+	} else {
+		c.Write([]byte{5, 2})
+		head = read(2)
+		if head == nil {
+			return
+		}
+		user := read(int(head[1]))
+		n := read(1)
+		if n == nil {
+			return
+		}
+		password := read(int(n[0]))
+		if string(user) != s.user || string(password) != s.password {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+	//
+	// Connect request
+	//
+	req := read(4)
+	if req == nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		n := read(1)
+		host = string(read(int(n[0])))
+	case 4:
+		host = net.IP(read(16)).String()
+	}
+	port := binary.BigEndian.Uint16(read(2))
+	s.mu.Lock()
+	s.addresses = append(s.addresses, fmt.Sprintf("%s:%d", host, port))
+	s.mu.Unlock()
+	backend, err := net.Dial("tcp", s.backend)
+	if err != nil {
+		c.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(backend, c)
+	io.Copy(c, backend)
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	var host string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host = req.Host
+	}))
+	defer srv.Close()
+	socks := newSocksServer(t, srv.Listener.Addr().String(), "jtkirk", "hunter2")
+	defer socks.Close()
+	s := Session{Proxy: &Proxy{
+		Hosts: map[string]string{"example.test": "socks5://jtkirk:hunter2@" + socks.Addr().String()},
+	}}
+	resp, err := s.Get("http://api.example.test:8080/", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, "api.example.test:8080", host)
+	assert.Equal(t, []string{"api.example.test:8080"}, socks.addresses)
+	//
+	// Wrong credentials
+	//
+	s = Session{Proxy: &Proxy{URL: "socks5://jtkirk:wrong@" + socks.Addr().String()}}
+	_, err = s.Get("http://api.example.test:8080/", nil, nil, nil)
+	assert.NotNil(t, err)
+}
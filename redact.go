@@ -0,0 +1,208 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the redaction of secrets from logged requests and
+responses.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Redacted replaces secret values in logs.
+const Redacted = "REDACTED"
+
+// A Redaction describes the secrets hidden from logs, and how much of each
+// body is logged.
+type Redaction struct {
+	Headers []string // Header names, matched case-insensitively
+	Params  []string // Query parameter and form field names
+
+	// Fields are dot-separated paths of fields in JSON bodies, such as
+	// "user.password".  Arrays are traversed transparently, so "items.token"
+	// also matches the token of every element of an items array.
+	Fields []string
+
+	// MaxBodyBytes is the number of bytes of each body that are logged; 0
+	// means no limit.
+	MaxBodyBytes int
+}
+
+// DefaultRedaction hides credentials and cookies, and logs up to 4KiB of
+// each body.
+var DefaultRedaction = Redaction{
+	Headers:      []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	MaxBodyBytes: 4096,
+}
+
+// redaction returns the Session's Redaction, or DefaultRedaction.
+func (s *Session) redaction() *Redaction {
+	if s.Redaction != nil {
+		return s.Redaction
+	}
+	return &DefaultRedaction
+}
+
+// isParam reports whether the query parameter or form field name is secret.
+func (rd *Redaction) isParam(name string) bool {
+	for _, p := range rd.Params {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isHeader reports whether the header name is secret.
+func (rd *Redaction) isHeader(name string) bool {
+	for _, h := range rd.Headers {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// header returns a copy of h with secret values replaced.
+func (rd *Redaction) header(h http.Header) http.Header {
+	h = h.Clone()
+	for name, vs := range h {
+		if rd.isHeader(name) {
+			for i := range vs {
+				vs[i] = Redacted
+			}
+		}
+	}
+	return h
+}
+
+// values returns a copy of v with secret values replaced.
+func (rd *Redaction) values(v url.Values) url.Values {
+	out := url.Values{}
+	for k, vs := range v {
+		if rd.isParam(k) {
+			vs = []string{Redacted}
+		}
+		out[k] = vs
+	}
+	return out
+}
+
+// url returns u as a string, with any password and secret query parameters
+// replaced.
+func (rd *Redaction) url(u *url.URL) string {
+	c := *u
+	if len(rd.Params) > 0 && c.RawQuery != "" {
+		c.RawQuery = rd.values(c.Query()).Encode()
+	}
+	return c.Redacted()
+}
+
+// err returns e with secrets redacted from its URL, if it is a *url.Error,
+// as returned by net/http.
+func (rd *Redaction) err(e error) error {
+	ue, ok := e.(*url.Error)
+	if !ok {
+		return e
+	}
+	u, perr := url.Parse(ue.URL)
+	if perr != nil {
+		return e
+	}
+	c := *ue
+	c.URL = rd.url(u)
+	return &c
+}
+
+// body returns a loggable rendering of a body of the given Content-Type.
+// JSON bodies are pretty-printed with secret fields replaced, form bodies
+// have secret fields replaced, and binary bodies are summarized.  The result
+// is truncated to MaxBodyBytes.
+func (rd *Redaction) body(data []byte, contentType string) string {
+	if len(data) == 0 {
+		return "Empty body"
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if !utf8.Valid(data) || isBinaryMediaType(mt) {
+		return fmt.Sprintf("(%d bytes of binary data, type %q)", len(data), contentType)
+	}
+	text := string(data)
+	if v, ok := rd.json(data); ok {
+		text = pretty(v)
+	} else if mt == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(text); err == nil {
+			text = rd.values(form).Encode()
+		}
+	}
+	if rd.MaxBodyBytes > 0 && len(text) > rd.MaxBodyBytes {
+		text = fmt.Sprintf("%s... (%d bytes total)", text[:rd.MaxBodyBytes], len(text))
+	}
+	return text
+}
+
+// json decodes a JSON body and replaces its secret fields.  It reports false
+// if data is not JSON.
+func (rd *Redaction) json(data []byte) (interface{}, bool) {
+	var v interface{}
+	if json.Unmarshal(data, &v) != nil {
+		return nil, false
+	}
+	for _, f := range rd.Fields {
+		redactField(v, strings.Split(f, "."))
+	}
+	return v, true
+}
+
+// redactField replaces the value at path in a decoded JSON value.
+func redactField(v interface{}, path []string) {
+	switch x := v.(type) {
+	case []interface{}:
+		for _, e := range x {
+			redactField(e, path)
+		}
+	case map[string]interface{}:
+		child, ok := x[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			x[path[0]] = Redacted
+			return
+		}
+		redactField(child, path[1:])
+	}
+}
+
+// isBinaryMediaType reports whether bodies of media type mt are binary,
+// whatever their content.
+func isBinaryMediaType(mt string) bool {
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "json"),
+		strings.HasSuffix(mt, "xml"),
+		mt == "application/x-www-form-urlencoded",
+		mt == "application/javascript",
+		mt == "":
+		return false
+	case strings.HasPrefix(mt, "image/"),
+		strings.HasPrefix(mt, "audio/"),
+		strings.HasPrefix(mt, "video/"),
+		mt == "application/octet-stream",
+		mt == "application/pdf",
+		mt == "application/zip",
+		mt == "application/gzip":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements redirect policies and redirect history.
+*/
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// A RedirectPolicy describes how redirects are followed.  Without one, the
+// http.Client's own policy applies, which by default follows up to 10
+// redirects.
+type RedirectPolicy struct {
+	// Disable can be set to true to not follow redirects at all.  The
+	// redirect response is then returned as is.
+	Disable bool
+
+	// MaxHops is the maximum number of redirects followed before giving up
+	// with an error; default 10.
+	MaxHops int
+
+	// 307 and 308 redirects normally resend the original method and body.
+	// DowngradeMethod can be set to true to follow them with a GET and no
+	// body instead, as is always done for 301, 302 and 303.
+	DowngradeMethod bool
+}
+
+// check implements http.Client.CheckRedirect for the policy.
+func (p *RedirectPolicy) check(req *http.Request, via []*http.Request) error {
+	if p.Disable {
+		return http.ErrUseLastResponse
+	}
+	max := p.MaxHops
+	if max <= 0 {
+		max = 10
+	}
+	if len(via) > max {
+		return fmt.Errorf("napping: stopped after %d redirects", max)
+	}
+	if p.DowngradeMethod && req.Method != "GET" && req.Method != "HEAD" {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		req.Method = "GET"
+		req.Body = nil
+		req.GetBody = nil
+		req.ContentLength = 0
+		req.Header.Del("Content-Type")
+	}
+	return nil
+}
+
+// A Redirect is one hop in the chain of redirects followed to get a
+// response.
+type Redirect struct {
+	StatusCode int      // Status of the redirect response
+	URL        *url.URL // URL that was redirected
+	Location   string   // Location header of the redirect response
+}
+
+// FinalURL returns the URL of the request that produced the response, after
+// following any redirects.
+func (r *Response) FinalURL() *url.URL {
+	if r.response == nil || r.response.Request == nil {
+		return nil
+	}
+	return r.response.Request.URL
+}
+
+// Redirects returns the redirects followed to get the response, in the order
+// they happened.
+func (r *Response) Redirects() []Redirect {
+	if r.response == nil {
+		return nil
+	}
+	hops := []Redirect{}
+	for req := r.response.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		prev := req.Response
+		hop := Redirect{
+			StatusCode: prev.StatusCode,
+			Location:   prev.Header.Get("Location"),
+		}
+		if prev.Request != nil {
+			hop.URL = prev.Request.URL
+		}
+		hops = append([]Redirect{hop}, hops...)
+	}
+	return hops
+}
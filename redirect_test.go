@@ -0,0 +1,150 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type echoedRequest struct {
+	Method string
+	Body   string
+}
+
+// redirectServer redirects /a to /b with a 302 and /b to /c with a 307;
+// /c echoes the method and body of the request.
+func redirectServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/b", http.StatusFound)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/c", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/c", func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		blob, _ := JSONCodec{}.Marshal(echoedRequest{req.Method, string(b)})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(blob)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/loop", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestRedirectHistory(t *testing.T) {
+	srv := redirectServer()
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	res := echoedRequest{}
+	resp, err := Get(u+"/a", nil, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, u+"/c", resp.FinalURL().String())
+	hops := resp.Redirects()
+	if assert.Equal(t, 2, len(hops)) {
+		assert.Equal(t, 302, hops[0].StatusCode)
+		assert.Equal(t, u+"/a", hops[0].URL.String())
+		assert.Equal(t, "/b", hops[0].Location)
+		assert.Equal(t, 307, hops[1].StatusCode)
+		assert.Equal(t, u+"/b", hops[1].URL.String())
+		assert.Equal(t, "/c", hops[1].Location)
+	}
+	//
+	// No redirects
+	//
+	resp, err = Get(u+"/c", nil, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(resp.Redirects()))
+	assert.Equal(t, u+"/c", resp.FinalURL().String())
+}
+
+func TestRedirectDisable(t *testing.T) {
+	srv := redirectServer()
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	s := Session{Redirect: &RedirectPolicy{Disable: true}}
+	resp, err := s.Get(u+"/a", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 302, resp.Status())
+	assert.Equal(t, "/b", resp.HttpResponse().Header.Get("Location"))
+	assert.Equal(t, u+"/a", resp.FinalURL().String())
+	//
+	// The policy applies per request, without changing the session's client
+	//
+	r := Request{
+		Url:      u + "/a",
+		Method:   "GET",
+		Redirect: &RedirectPolicy{},
+	}
+	resp, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	resp, err = s.Get(u+"/a", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 302, resp.Status())
+	assert.Nil(t, s.Client)
+}
+
+func TestRedirectMaxHops(t *testing.T) {
+	srv := redirectServer()
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	s := Session{Redirect: &RedirectPolicy{MaxHops: 1}}
+	_, err := s.Get(u+"/a", nil, nil, nil)
+	assert.NotNil(t, err)
+	s.Redirect.MaxHops = 2
+	resp, err := s.Get(u+"/a", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	s.Redirect.MaxHops = 0
+	_, err = s.Get(u+"/loop", nil, nil, nil)
+	assert.Contains(t, err.Error(), "stopped after 10 redirects")
+}
+
+func TestRedirectMethod(t *testing.T) {
+	srv := redirectServer()
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	//
+	// 307 keeps the method and body by default
+	//
+	s := Session{}
+	res := echoedRequest{}
+	_, err := s.Post(u+"/b", &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, echoedRequest{"POST", `{"Foo":111,"Bar":"foo"}`}, res)
+	//
+	// Unless told otherwise
+	//
+	s.Redirect = &RedirectPolicy{DowngradeMethod: true}
+	resp, err := s.Post(u+"/b", &fooStruct, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, echoedRequest{"GET", ""}, res)
+	assert.Equal(t, "", resp.HttpResponse().Request.Header.Get("Content-Type"))
+}
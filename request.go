@@ -8,6 +8,8 @@ package napping
 import (
 	"bytes"
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
@@ -34,38 +36,90 @@ type Request struct {
 	Url     string      // Raw URL string
 	Method  string      // HTTP method to use
 	Params  *url.Values // URL query parameters
-	Payload interface{} // Data to JSON-encode and POST
+	Payload interface{} // Data to encode (JSON by default) and send
 
-	// Can be set to true if Payload is of type *bytes.Buffer and client wants
-	// to send it as-is
+	// Can be set to true if Payload is an io.Reader and client wants to send
+	// it as-is
 	RawPayload bool
 
-	// Result is a pointer to a data structure.  On success (HTTP status < 300),
-	// response from server is unmarshaled into Result.
+	// ContentLength is the length of a raw Payload, if known.  It need not
+	// be set for payloads that implement io.Seeker, whose length is
+	// measured.  Otherwise the body is sent with chunked encoding.
+	ContentLength int64
+
+	// GetBody optionally returns a new copy of a raw Payload, so the body can
+	// be sent again after a redirect or when retrying.  If Payload is nil,
+	// GetBody is also used for the first attempt.  Without GetBody, only
+	// payloads that are *bytes.Buffers or implement io.Seeker can be sent
+	// more than once.
+	GetBody func() (io.ReadCloser, error)
+
+	// Result is a pointer to a data structure.  Responses with a status the
+	// DecodePolicy sends to Result, by default 1xx and 2xx, are unmarshaled
+	// into it; see DefaultDecodePolicy.
 	Result interface{}
 
+	// Stream can be set to true to leave the response body unread, so it can
+	// be processed as it arrives.  Result or Error are then decoded
+	// incrementally from the start of the stream, and the rest of it is
+	// available from Response.Body and Response.Decode.  The caller must
+	// close Response.Body.
+	Stream bool
+
 	// CaptureResponseBody can be set to capture the response body for external use.
 	CaptureResponseBody bool
 
 	// ResponseBody exports the raw response body if CaptureResponseBody is true.
 	ResponseBody *bytes.Buffer
 
-	// Error is a pointer to a data structure.  On error (HTTP status >= 300),
-	// response from server is unmarshaled into Error.
+	// Error is a pointer to a data structure.  Responses with a status the
+	// DecodePolicy sends to Error, by default 400 and above, are unmarshaled
+	// into it; see DefaultDecodePolicy.
 	Error interface{}
 
 	// Optional
 	Userinfo *url.Userinfo
 	Header   *http.Header
 
-	// Custom Transport if needed.
+	// Auth overrides the Session's Authenticator for this request.
+	Auth Authenticator
+
+	// Signer overrides the Session's Signer for this request.
+	Signer Signer
+
+	// Transport overrides the Session's transport for this request.  The
+	// Session's Proxy and TLS settings are applied to a copy of it, which
+	// the Session caches; reuse the same Transport across requests, or its
+	// connections are not shared.
 	Transport *http.Transport
 
+	// Timeouts overrides the non-zero timeouts of the Session for this
+	// request.
+	Timeouts *Timeouts
+
+	// Retry overrides the Session's retry policy for this request.
+	Retry *RetryPolicy
+
+	// DecodePolicy overrides the Session's decode policy for this request.
+	DecodePolicy *DecodePolicy
+
+	// Redirect overrides the Session's redirect policy for this request.
+	Redirect *RedirectPolicy
+
+	// Codec overrides the Session's codec for encoding Payload.  It is also
+	// used to decode the response if it matches the response's Content-Type.
+	Codec Codec
+
 	// The following fields are populated by Send().
 	timestamp time.Time      // Time when HTTP request was sent
 	status    int            // HTTP status for executed request
 	response  *http.Response // Response object from http package
 	body      []byte         // Body of server's response (JSON or otherwise)
+	attempts  int            // Number of attempts made to send the request
+	codec     Codec          // Codec chosen to decode the response
+	decoder   Decoder        // Decoder reading a streamed response
+	decodeErr error          // Error decoding the response into Error
+	prepared  *prepared      // Merged URL, headers and body that were sent
 }
 
 // A Response is a Request object that has been executed.
@@ -76,6 +130,12 @@ func (r *Response) Timestamp() time.Time {
 	return r.timestamp
 }
 
+// Attempts returns the number of times the request was sent, including
+// retries.
+func (r *Response) Attempts() int {
+	return r.attempts
+}
+
 // RawText returns the body of the server's response as raw text.
 func (r *Response) RawText() string {
 	return strings.TrimSpace(string(r.body))
@@ -92,8 +152,40 @@ func (r *Response) HttpResponse() *http.Response {
 	return r.response
 }
 
-// Unmarshal parses the JSON-encoded data in the server's response, and stores
-// the result in the value pointed to by v.
+// DecodeError returns the error, if any, that occurred while decoding the
+// response body into Error, e.g. because the server answered with an HTML
+// error page.
+func (r *Response) DecodeError() error {
+	return r.decodeErr
+}
+
+// Body returns the live body of a streamed response, which the caller must
+// close.  For a response that was not streamed, Body returns the buffered
+// body.
+func (r *Response) Body() io.ReadCloser {
+	if r.Stream {
+		return r.response.Body
+	}
+	return ioutil.NopCloser(bytes.NewReader(r.body))
+}
+
+// Decode decodes the next value from a streamed response into v, and returns
+// io.EOF once the stream is exhausted.  Values are read incrementally if the
+// response's Codec is a StreamCodec.  For a response that was not streamed,
+// Decode is the same as Unmarshal.
+func (r *Response) Decode(v interface{}) error {
+	if !r.Stream {
+		return r.Unmarshal(v)
+	}
+	return r.decoder.Decode(v)
+}
+
+// Unmarshal parses the encoded data in the server's response, and stores the
+// result in the value pointed to by v.  The data is decoded with the Codec
+// matching the response's Content-Type, or as JSON if there is none.
 func (r *Response) Unmarshal(v interface{}) error {
-	return json.Unmarshal(r.body, v)
+	if r.codec == nil {
+		return json.Unmarshal(r.body, v)
+	}
+	return r.codec.Unmarshal(r.body, v)
 }
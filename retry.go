@@ -0,0 +1,166 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements retrying of failed requests.
+*/
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryStatuses are the HTTP statuses retried when a RetryPolicy does
+// not specify its own.
+var DefaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// A RetryPolicy describes when and how often a failed request is retried.
+// Requests are retried after transport errors, and after responses whose
+// status is listed in Statuses.  Delays grow exponentially from MinBackoff up
+// to MaxBackoff, unless the server asks for a specific delay with a
+// Retry-After header.
+type RetryPolicy struct {
+	MaxAttempts int           // Total number of attempts, including the first
+	MinBackoff  time.Duration // Delay before the first retry; default 100ms
+	MaxBackoff  time.Duration // Upper bound for a single delay; default 10s
+	MaxElapsed  time.Duration // Total time budget for all attempts; 0 is unlimited
+
+	// Jitter is the fraction, between 0 and 1, of each delay that is
+	// randomized.  With a Jitter of 0.5 a 1s delay becomes anywhere between
+	// 500ms and 1s.
+	Jitter float64
+
+	// Statuses lists the HTTP statuses that are retried.  If nil,
+	// DefaultRetryStatuses is used.
+	Statuses []int
+
+	// By default only idempotent methods (GET, HEAD, OPTIONS, PUT, DELETE and
+	// TRACE) are retried.  Set RetryNonIdempotent to also retry POST and PATCH.
+	RetryNonIdempotent bool
+}
+
+// isIdempotent reports whether method is idempotent as defined by RFC 7231.
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE", "TRACE":
+		return true
+	}
+	return false
+}
+
+// retryable reports whether an attempt that ended with resp and err should be
+// retried.
+func (p *RetryPolicy) retryable(method string, resp *http.Response, err error) bool {
+	if !p.RetryNonIdempotent && !isIdempotent(method) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	statuses := p.Statuses
+	if statuses == nil {
+		statuses = DefaultRetryStatuses
+	}
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry, where retry 1 follows the
+// first attempt.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	min := p.MinBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+	d := min
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		j := p.Jitter
+		if j > 1 {
+			j = 1
+		}
+		d -= time.Duration(j * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// next decides whether another attempt should be made after attempt number
+// attempt, which ended with resp and err.  If so it returns the delay to wait
+// before making it.  A nil RetryPolicy never retries.
+func (p *RetryPolicy) next(method string, attempt int, start time.Time, resp *http.Response, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts || !p.retryable(method, resp, err) {
+		return 0, false
+	}
+	d := p.backoff(attempt)
+	if resp != nil {
+		if ra, ok := retryAfter(resp, time.Now()); ok {
+			d = ra
+		}
+	}
+	if p.MaxElapsed > 0 && time.Since(start)+d > p.MaxElapsed {
+		return 0, false
+	}
+	return d, true
+}
+
+// retryAfter parses the Retry-After header of resp, which holds either a
+// number of seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// sleep waits for d to elapse, or returns early with ctx.Err() if ctx is done
+// first.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
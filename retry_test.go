@@ -0,0 +1,201 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var fastRetry = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  time.Millisecond,
+	MaxBackoff:  5 * time.Millisecond,
+}
+
+// flakyHandler fails with status for the first n requests, then echoes the
+// request body back.
+func flakyHandler(n int32, status int, calls *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if atomic.AddInt32(calls, 1) <= n {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+func TestRetryStatus(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(flakyHandler(2, http.StatusServiceUnavailable, &calls))
+	defer srv.Close()
+	policy := fastRetry
+	s := Session{Retry: &policy}
+	resp, err := s.Put("http://"+srv.Listener.Addr().String(), &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, 3, resp.Attempts())
+	assert.Equal(t, int32(3), calls)
+	res := structType{}
+	assert.Nil(t, resp.Unmarshal(&res))
+	assert.Equal(t, fooStruct, res)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(flakyHandler(5, http.StatusBadGateway, &calls))
+	defer srv.Close()
+	policy := fastRetry
+	s := Session{Retry: &policy}
+	resp, err := s.Get("http://"+srv.Listener.Addr().String(), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 502, resp.Status())
+	assert.Equal(t, 3, resp.Attempts())
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(flakyHandler(1, http.StatusServiceUnavailable, &calls))
+	defer srv.Close()
+	policy := fastRetry
+	s := Session{Retry: &policy}
+	url := "http://" + srv.Listener.Addr().String()
+	resp, err := s.Post(url, &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 503, resp.Status())
+	assert.Equal(t, 1, resp.Attempts())
+	//
+	// Opt in per request, with a raw payload that must be replayed
+	//
+	atomic.StoreInt32(&calls, 0)
+	optIn := fastRetry
+	optIn.RetryNonIdempotent = true
+	res := structType{}
+	r := Request{
+		Url:        url,
+		Method:     "POST",
+		Payload:    bytes.NewBufferString(`{"Foo": 111, "Bar": "foo"}`),
+		RawPayload: true,
+		Result:     &res,
+		Retry:      &optIn,
+	}
+	resp, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, 2, resp.Attempts())
+	assert.Equal(t, fooStruct, res)
+}
+
+func TestRetryTransportError(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	policy := fastRetry
+	s := Session{Retry: &policy}
+	resp, err := s.Get("http://"+srv.Listener.Addr().String(), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, 2, resp.Attempts())
+}
+
+func TestRetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	url := "http://" + srv.Listener.Addr().String()
+	//
+	// Retry-After exceeds the time budget, so no retry is made
+	//
+	policy := fastRetry
+	policy.MaxElapsed = 500 * time.Millisecond
+	s := Session{Retry: &policy}
+	resp, err := s.Get(url, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 429, resp.Status())
+	assert.Equal(t, 1, resp.Attempts())
+	//
+	// Without a budget the delay is honored
+	//
+	atomic.StoreInt32(&calls, 0)
+	policy.MaxElapsed = 0
+	start := time.Now()
+	resp, err = s.Get(url, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, resp.Status())
+	assert.True(t, time.Since(start) >= time.Second)
+}
+
+func TestRetryAfterParse(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	resp := &http.Response{Header: http.Header{}}
+	_, ok := retryAfter(resp, now)
+	assert.False(t, ok)
+	resp.Header.Set("Retry-After", "120")
+	d, ok := retryAfter(resp, now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+	resp.Header.Set("Retry-After", "Wed, 21 Oct 2015 07:28:30 GMT")
+	d, ok = retryAfter(resp, now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+	resp.Header.Set("Retry-After", "soon")
+	_, ok = retryAfter(resp, now)
+	assert.False(t, ok)
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: time.Second,
+	}
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, p.backoff(4))
+	assert.Equal(t, time.Second, p.backoff(5))
+	assert.Equal(t, time.Second, p.backoff(50))
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(3)
+		assert.True(t, d > 200*time.Millisecond && d <= 400*time.Millisecond)
+	}
+}
@@ -12,14 +12,16 @@ requests (cookies, auth, proxies).
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,16 +30,281 @@ type Session struct {
 	Client *http.Client
 	Log    bool // Log request and response
 
+	// Jar stores cookies unless Client is set; it is created by the first
+	// request if nil.
+	Jar *CookieJar
+
+	// Logger receives structured events for every request and response.
+	// If Log is also set, the detailed request and response dump is sent
+	// to Logger at debug level instead of the standard logger.
+	Logger Logger
+
+	// Redaction decides which secrets are hidden from logs.  If nil,
+	// DefaultRedaction is used.
+	Redaction *Redaction
+
+	// HTTPErrors can be set to true to return an *HTTPError, along with the
+	// Response, when the response status is an error according to the
+	// DecodePolicy; by default, when it is 400 or above.
+	HTTPErrors bool
+
+	// DecodePolicy decides which responses are decoded into Result and
+	// which into Error.  If nil, DefaultDecodePolicy is used.
+	DecodePolicy *DecodePolicy
+
+	// HAR, if set, records every exchange as an HTTP Archive.
+	HAR *HARRecorder
+
+	// Proxy decides which proxy requests are sent through.  If nil, the
+	// Transport's setting is used; by default, the environment's.  It is
+	// not applied to the Transport of Client.
+	Proxy *Proxy
+
+	// TLS configures certificates, root CAs and pinning; like Proxy, it
+	// is not applied to the Transport of Client.
+	TLS *TLS
+
+	// Timeouts limits the duration of each phase of requests; see
+	// Timeouts.
+	Timeouts *Timeouts
+
+	// Redirect decides how redirects are followed.  If nil, the Client's
+	// policy is used.
+	Redirect *RedirectPolicy
+
 	// Optional
 	Userinfo *url.Userinfo
 
+	// Auth authenticates every request; see Authenticator.
+	Auth Authenticator
+
+	// Signer signs every request; see Signer.
+	Signer Signer
+
 	// Optional defaults - can be overridden in a Request
 	Header *http.Header
 	Params *url.Values
+	Retry  *RetryPolicy // Nil disables retries
+
+	// Middleware wraps every attempt to send a request; see Middleware.
+	Middleware []Middleware
+
+	// Codec encodes payloads and decodes responses; JSON if nil.  Use
+	// FormCodec to send URL-encoded forms.  Codecs
+	// lists additional codecs used to decode responses based on their
+	// Content-Type.
+	Codec  Codec
+	Codecs []Codec
+
+	mu         sync.Mutex
+	transports map[transportKey]*cachedTransport // See client.go
+	uses       uint64                            // Calls to transport, to find the least recently used
 }
 
 // Send constructs and sends an HTTP request.
-func (s *Session) Send(r *Request) (response *Response, err error) {
+func (s *Session) Send(r *Request) (*Response, error) {
+	return s.SendContext(context.Background(), r)
+}
+
+// SendContext constructs and sends an HTTP request, which is bound to ctx.  If
+// ctx is cancelled or its deadline expires before the response has been read
+// and unmarshaled, SendContext stops and returns ctx.Err(), so callers can
+// test the error against context.Canceled or context.DeadlineExceeded.
+func (s *Session) SendContext(ctx context.Context, r *Request) (response *Response, err error) {
+	timeouts := s.timeouts(r)
+	parent, stop := ctx, func() {}
+	if timeouts.Total > 0 {
+		ctx, stop = context.WithTimeout(ctx, timeouts.Total)
+	}
+	streaming := false
+	defer func() {
+		switch {
+		case err == nil:
+		case parent.Err() != nil:
+			err = parent.Err()
+		case ctx.Err() != nil:
+			err = &TimeoutError{Phase: PhaseTotal, Duration: timeouts.Total, Err: err}
+		default:
+			err = timeouts.wrap(err)
+		}
+		if !streaming {
+			stop()
+		}
+	}()
+	prep, err := s.prepare(r)
+	if err != nil {
+		return
+	}
+	u, header, body := prep.url, prep.header, prep.body
+	r.prepared = prep
+	//
+	// Execute the HTTP request, retrying according to the retry policy
+	//
+	client, err := s.client(r)
+	if err != nil {
+		return
+	}
+	retry := s.Retry
+	if r.Retry != nil {
+		retry = r.Retry
+	}
+	var handler Handler = func(req *http.Request) (*Response, error) {
+		cancel := func() {}
+		if timeouts.BodyRead > 0 {
+			req, cancel = cancellable(req)
+		}
+		req, timer := s.HAR.begin(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			s.HAR.record(timer, req, nil, nil, err)
+			return nil, err
+		}
+		if timeouts.BodyRead > 0 {
+			resp.Body = newTimedBody(resp.Body, timeouts.BodyRead, cancel)
+		}
+		rsp := Response(*r)
+		rsp.status = resp.StatusCode
+		rsp.response = resp
+		if r.Stream {
+			s.HAR.record(timer, req, resp, nil, nil)
+			return &rsp, nil
+		}
+		defer resp.Body.Close()
+		rsp.body, err = ioutil.ReadAll(resp.Body)
+		s.HAR.record(timer, req, resp, rsp.body, err)
+		if err != nil {
+			return nil, err
+		}
+		return &rsp, nil
+	}
+	// The signer sees the request as changed by middleware
+	if signer := s.signer(r); signer != nil {
+		handler = signed(signer, handler)
+	}
+	handler = s.chain(handler)
+	if auth := s.authenticator(r); auth != nil {
+		handler = authenticated(auth, handler)
+	}
+	start := time.Now()
+	var resp *http.Response
+	for r.attempts = 1; ; r.attempts++ {
+		var req *http.Request
+		req, err = newHTTPRequest(ctx, r.Method, u, header, body)
+		if err != nil {
+			s.log(err)
+			return
+		}
+		s.logRequest(req, r, body)
+		s.logAttempt(ctx, req, r.attempts)
+		r.timestamp = time.Now()
+		var rsp *Response
+		rsp, err = handler(req)
+		resp = nil
+		if err == nil && (rsp == nil || rsp.response == nil) {
+			err = errors.New("Middleware returned no HTTP response")
+		}
+		s.logOutcome(ctx, req, r.attempts, time.Since(r.timestamp), rsp, err)
+		if err != nil {
+			s.log(s.redaction().err(err))
+		} else {
+			resp = rsp.response
+			r.body = rsp.body
+		}
+		delay, ok := retry.next(r.Method, r.attempts, start, resp, err)
+		if !ok || !body.replayable() {
+			break
+		}
+		if r.Stream && resp != nil {
+			resp.Body.Close()
+		}
+		s.log("Retrying in", delay)
+		s.logRetry(ctx, req, r.attempts, delay)
+		if err = sleep(ctx, delay); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		return
+	}
+	r.status = resp.StatusCode
+	r.response = resp
+	if r.Stream {
+		// The Total timeout runs until the caller closes the body
+		resp.Body = totalBody{resp.Body, ctx, timeouts.Total, stop}
+		streaming = true
+	}
+
+	//
+	// Unmarshal
+	//
+	if err = ctx.Err(); err != nil {
+		s.log(err)
+		if r.Stream {
+			resp.Body.Close()
+		}
+		return
+	}
+	r.codec = s.decoder(r, resp.Header.Get("Content-Type"))
+	decode := s.decodePolicy(r)
+	toResult := r.Result != nil && decode.isResult(resp.StatusCode)
+	toError := r.Error != nil && decode.isError(resp.StatusCode)
+	if r.Stream {
+		//
+		// Decode incrementally from the stream, leaving the rest of it to
+		// the caller.  An empty body is not an error.
+		//
+		r.decoder = newDecoder(r.codec, resp.Body)
+		if toResult {
+			err = r.decoder.Decode(r.Result)
+		}
+		if toError {
+			r.decodeErr = r.decoder.Decode(r.Error)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if r.decodeErr == io.EOF {
+			r.decodeErr = nil
+		}
+	} else if string(r.body) != "" {
+		if toResult {
+			err = r.codec.Unmarshal(r.body, r.Result)
+		}
+		if toError {
+			r.decodeErr = r.codec.Unmarshal(r.body, r.Error)
+		}
+	}
+	if r.decodeErr != nil {
+		s.log("Error decoding response into Error:", r.decodeErr)
+	}
+	if r.CaptureResponseBody {
+		r.ResponseBody = bytes.NewBuffer(r.body)
+	}
+	rsp := Response(*r)
+	response = &rsp
+
+	s.logResponse(response)
+	if s.HTTPErrors && err == nil && decode.isError(resp.StatusCode) {
+		err = newHTTPError(response, s.redaction())
+	}
+
+	return
+}
+
+// A prepared request has its URL, headers and body merged from the Session
+// and the Request, ready to be sent.
+type prepared struct {
+	url       *url.URL
+	header    http.Header
+	body      *body
+	userinfo  *url.Userinfo // Credentials used for HTTP Basic authentication
+	redaction *Redaction    // Secrets hidden when the request is displayed
+}
+
+// prepare merges the Session and Request options for r and encodes its
+// payload.
+func (s *Session) prepare(r *Request) (*prepared, error) {
 	r.Method = strings.ToUpper(r.Method)
 	//
 	// Create a URL object from the raw url string.  This will allow us to compose
@@ -47,7 +314,7 @@ func (s *Session) Send(r *Request) (response *Response, err error) {
 	if err != nil {
 		s.log("URL", r.Url)
 		s.log(err)
-		return
+		return nil, err
 	}
 	//
 	// Default query parameters
@@ -83,8 +350,8 @@ func (s *Session) Send(r *Request) (response *Response, err error) {
 	//
 	r.Params = &p
 	//
-	// Create a Request object; if populated, Data field is JSON encoded as
-	// request body
+	// Encode the payload.  The body is kept in a form that can be replayed if
+	// the request has to be retried.
 	//
 	header := http.Header{}
 	if s.Header != nil {
@@ -93,46 +360,28 @@ func (s *Session) Send(r *Request) (response *Response, err error) {
 			header.Set(k, v)
 		}
 	}
-	var req *http.Request
-	var buf *bytes.Buffer
-	if r.Payload != nil {
-		if r.RawPayload {
-			var ok bool
-			// buf can be nil interface at this point
-			// so we'll do extra nil check
-			buf, ok = r.Payload.(*bytes.Buffer)
-			if !ok {
-				err = errors.New("Payload must be of type *bytes.Buffer if RawPayload is set to true")
-				return
-			}
-
-			// do not overwrite the content type with raw payload
-		} else {
-			var b []byte
-			b, err = json.Marshal(&r.Payload)
-			if err != nil {
-				s.log(err)
-				return
-			}
-			buf = bytes.NewBuffer(b)
-
-			// Overwrite the content type to json since we're pushing the payload as json
-			header.Set("Content-Type", "application/json")
-		}
-		if buf != nil {
-			req, err = http.NewRequest(r.Method, u.String(), buf)
-		} else {
-			req, err = http.NewRequest(r.Method, u.String(), nil)
-		}
+	var data []byte
+	var body *body
+	if m, ok := r.Payload.(*Multipart); ok && m != nil && !r.RawPayload {
+		header.Set("Content-Type", m.ContentType())
+		body = m.newBody()
+	} else if r.Payload != nil && !r.RawPayload {
+		codec := s.encoder(r)
+		data, err = codec.Marshal(r.Payload)
 		if err != nil {
 			s.log(err)
-			return
+			return nil, err
 		}
-	} else { // no data to encode
-		req, err = http.NewRequest(r.Method, u.String(), nil)
+
+		// Overwrite the content type since we're pushing the payload as encoded by codec
+		header.Set("Content-Type", codec.ContentType())
+	}
+	// do not overwrite the content type with raw payload
+	if body == nil {
+		body, err = newBody(r, data)
 		if err != nil {
 			s.log(err)
-			return
+			return nil, err
 		}
 	}
 	//
@@ -155,110 +404,38 @@ func (s *Session) Send(r *Request) (response *Response, err error) {
 		}
 	}
 	if header.Get("Accept") == "" {
-		header.Add("Accept", "application/json") // Default, can be overridden with Opts
+		accept := s.encoder(r).Accept()
+		if accept == "" {
+			accept = s.responseCodec().Accept()
+		}
+		header.Add("Accept", accept) // Default, can be overridden with Opts
 	}
-	req.Header = header
 	//
 	// Set HTTP Basic authentication if userinfo is supplied
 	//
 	if userinfo != nil {
 		pwd, _ := userinfo.Password()
-		req.SetBasicAuth(userinfo.Username(), pwd)
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(userinfo.Username()+":"+pwd)))
 		if u.Scheme != "https" {
 			s.log("WARNING: Using HTTP Basic Auth in cleartext is insecure.")
 		}
 	}
-	//
-	// Execute the HTTP request
-	//
-
-	// Debug log request
-	if s.Log {
-		s.log("--------------------------------------------------------------------------------")
-		s.log("REQUEST")
-		s.log("--------------------------------------------------------------------------------")
-		s.log("Method:", req.Method)
-		s.log("URL:", req.URL)
-		s.log("Header:", req.Header)
-		s.log("Form:", req.Form)
-		s.log("Payload:")
-		if r.RawPayload && s.Log && buf != nil {
-			s.log(base64.StdEncoding.EncodeToString(buf.Bytes()))
-		} else {
-			s.log(pretty(r.Payload))
-		}
-	}
-
-	r.timestamp = time.Now()
-	var client *http.Client
-	if s.Client != nil {
-		client = s.Client
-	} else {
-		client = &http.Client{}
-		if r.Transport != nil {
-			client.Transport = r.Transport
-		}
-
-		s.Client = client
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		s.log(err)
-		return
-	}
-	defer resp.Body.Close()
-	r.status = resp.StatusCode
-	r.response = resp
-
-	//
-	// Unmarshal
-	//
-	r.body, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		s.log(err)
-		return
-	}
-	if string(r.body) != "" {
-		if resp.StatusCode < 300 && r.Result != nil {
-			err = json.Unmarshal(r.body, r.Result)
-		}
-		if resp.StatusCode >= 400 && r.Error != nil {
-			json.Unmarshal(r.body, r.Error) // Should we ignore unmarshal error?
-		}
-	}
-	if r.CaptureResponseBody {
-		r.ResponseBody = bytes.NewBuffer(r.body)
-	}
-	rsp := Response(*r)
-	response = &rsp
-
-	// Debug log response
-	if s.Log {
-		s.log("--------------------------------------------------------------------------------")
-		s.log("RESPONSE")
-		s.log("--------------------------------------------------------------------------------")
-		s.log("Status: ", response.status)
-		s.log("Header:")
-		s.log(pretty(response.HttpResponse().Header))
-		s.log("Body:")
-
-		if response.body != nil {
-			raw := json.RawMessage{}
-			if json.Unmarshal(response.body, &raw) == nil {
-				s.log(pretty(&raw))
-			} else {
-				s.log(pretty(response.RawText()))
-			}
-		} else {
-			s.log("Empty response body")
-		}
-	}
-
-	return
+	return &prepared{
+		url:       u,
+		header:    header,
+		body:      body,
+		userinfo:  userinfo,
+		redaction: s.redaction(),
+	}, nil
 }
 
 // Get sends a GET request.
 func (s *Session) Get(url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
+	return s.GetContext(context.Background(), url, p, result, errMsg)
+}
+
+// GetContext sends a GET request bound to ctx.
+func (s *Session) GetContext(ctx context.Context, url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
 	r := Request{
 		Method: "GET",
 		Url:    url,
@@ -266,33 +443,48 @@ func (s *Session) Get(url string, p *url.Values, result, errMsg interface{}) (*R
 		Result: result,
 		Error:  errMsg,
 	}
-	return s.Send(&r)
+	return s.SendContext(ctx, &r)
 }
 
 // Options sends an OPTIONS request.
 func (s *Session) Options(url string, result, errMsg interface{}) (*Response, error) {
+	return s.OptionsContext(context.Background(), url, result, errMsg)
+}
+
+// OptionsContext sends an OPTIONS request bound to ctx.
+func (s *Session) OptionsContext(ctx context.Context, url string, result, errMsg interface{}) (*Response, error) {
 	r := Request{
 		Method: "OPTIONS",
 		Url:    url,
 		Result: result,
 		Error:  errMsg,
 	}
-	return s.Send(&r)
+	return s.SendContext(ctx, &r)
 }
 
 // Head sends a HEAD request.
 func (s *Session) Head(url string, result, errMsg interface{}) (*Response, error) {
+	return s.HeadContext(context.Background(), url, result, errMsg)
+}
+
+// HeadContext sends a HEAD request bound to ctx.
+func (s *Session) HeadContext(ctx context.Context, url string, result, errMsg interface{}) (*Response, error) {
 	r := Request{
 		Method: "HEAD",
 		Url:    url,
 		Result: result,
 		Error:  errMsg,
 	}
-	return s.Send(&r)
+	return s.SendContext(ctx, &r)
 }
 
 // Post sends a POST request.
 func (s *Session) Post(url string, payload, result, errMsg interface{}) (*Response, error) {
+	return s.PostContext(context.Background(), url, payload, result, errMsg)
+}
+
+// PostContext sends a POST request bound to ctx.
+func (s *Session) PostContext(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
 	r := Request{
 		Method:  "POST",
 		Url:     url,
@@ -300,11 +492,16 @@ func (s *Session) Post(url string, payload, result, errMsg interface{}) (*Respon
 		Result:  result,
 		Error:   errMsg,
 	}
-	return s.Send(&r)
+	return s.SendContext(ctx, &r)
 }
 
 // Put sends a PUT request.
 func (s *Session) Put(url string, payload, result, errMsg interface{}) (*Response, error) {
+	return s.PutContext(context.Background(), url, payload, result, errMsg)
+}
+
+// PutContext sends a PUT request bound to ctx.
+func (s *Session) PutContext(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
 	r := Request{
 		Method:  "PUT",
 		Url:     url,
@@ -312,11 +509,16 @@ func (s *Session) Put(url string, payload, result, errMsg interface{}) (*Respons
 		Result:  result,
 		Error:   errMsg,
 	}
-	return s.Send(&r)
+	return s.SendContext(ctx, &r)
 }
 
 // Patch sends a PATCH request.
 func (s *Session) Patch(url string, payload, result, errMsg interface{}) (*Response, error) {
+	return s.PatchContext(context.Background(), url, payload, result, errMsg)
+}
+
+// PatchContext sends a PATCH request bound to ctx.
+func (s *Session) PatchContext(ctx context.Context, url string, payload, result, errMsg interface{}) (*Response, error) {
 	r := Request{
 		Method:  "PATCH",
 		Url:     url,
@@ -324,11 +526,16 @@ func (s *Session) Patch(url string, payload, result, errMsg interface{}) (*Respo
 		Result:  result,
 		Error:   errMsg,
 	}
-	return s.Send(&r)
+	return s.SendContext(ctx, &r)
 }
 
 // Delete sends a DELETE request.
 func (s *Session) Delete(url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
+	return s.DeleteContext(context.Background(), url, p, result, errMsg)
+}
+
+// DeleteContext sends a DELETE request bound to ctx.
+func (s *Session) DeleteContext(ctx context.Context, url string, p *url.Values, result, errMsg interface{}) (*Response, error) {
 	r := Request{
 		Method: "DELETE",
 		Url:    url,
@@ -336,14 +543,97 @@ func (s *Session) Delete(url string, p *url.Values, result, errMsg interface{})
 		Result: result,
 		Error:  errMsg,
 	}
-	return s.Send(&r)
+	return s.SendContext(ctx, &r)
+}
+
+// newHTTPRequest creates an http.Request with a fresh copy of header and a
+// body reading from the start of b.
+func newHTTPRequest(ctx context.Context, method string, u *url.URL, header http.Header, b *body) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header.Clone()
+	if b == nil {
+		return req, nil
+	}
+	req.Body, err = b.open()
+	if err != nil {
+		return nil, err
+	}
+	if b.length >= 0 {
+		req.ContentLength = b.length
+	}
+	if req.ContentLength == 0 && b.length == 0 {
+		req.Body = http.NoBody
+	}
+	if b.replayable() {
+		req.GetBody = b.copier()
+	}
+	return req, nil
+}
+
+// logRequest logs an outgoing request if logging is enabled.  Secrets are
+// redacted according to the Session's Redaction.
+func (s *Session) logRequest(req *http.Request, r *Request, b *body) {
+	if !s.Log {
+		return
+	}
+	rd := s.redaction()
+	s.log("--------------------------------------------------------------------------------")
+	s.log("REQUEST")
+	s.log("--------------------------------------------------------------------------------")
+	s.log("Method:", req.Method)
+	s.log("URL:", rd.url(req.URL))
+	s.log("Header:", rd.header(req.Header))
+	s.log("Form:", req.Form)
+	s.log("Payload:")
+	if m, ok := r.Payload.(*Multipart); ok && m != nil && !r.RawPayload {
+		s.log(pretty(rd.values(m.Fields)), len(m.Files), "files")
+	} else if b != nil && b.data != nil {
+		s.log(rd.body(b.data, req.Header.Get("Content-Type")))
+	} else if b != nil {
+		s.log("Streamed payload")
+	} else {
+		s.log(pretty(r.Payload))
+	}
+}
+
+// logResponse logs a received response if logging is enabled.  Secrets are
+// redacted according to the Session's Redaction.
+func (s *Session) logResponse(response *Response) {
+	if !s.Log {
+		return
+	}
+	rd := s.redaction()
+	header := response.HttpResponse().Header
+	s.log("--------------------------------------------------------------------------------")
+	s.log("RESPONSE")
+	s.log("--------------------------------------------------------------------------------")
+	s.log("Status: ", response.status)
+	s.log("Header:")
+	s.log(pretty(rd.header(header)))
+	s.log("Body:")
+
+	if response.Stream {
+		s.log("Streamed response body")
+	} else if response.body != nil {
+		s.log(rd.body(response.body, header.Get("Content-Type")))
+	} else {
+		s.log("Empty response body")
+	}
 }
 
 // Debug method for logging
 // Centralizing logging in one method
 // avoids spreading conditionals everywhere
 func (s *Session) log(args ...interface{}) {
-	if s.Log {
-		log.Println(args...)
+	if !s.Log {
+		return
+	}
+	if s.Logger != nil {
+		s.logDebug(args...)
+		return
 	}
+	log.Println(args...)
 }
@@ -7,6 +7,7 @@ package napping
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -18,6 +19,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jmcvetta/randutil"
 	"github.com/stretchr/testify/assert"
@@ -320,6 +322,55 @@ func TestRawPayloadFail(t *testing.T) {
 	}
 }
 
+func TestSendContextCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleBlock))
+	defer srv.Close()
+	s := Session{}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	r := Request{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: "GET",
+	}
+	resp, err := s.SendContext(ctx, &r)
+	assert.Nil(t, resp)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestGetContextDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handleBlock))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	url := "http://" + srv.Listener.Addr().String()
+	_, err := GetContext(ctx, url, nil, nil, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestSendContextCancelDuringBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Foo": `))
+		w.(http.Flusher).Flush()
+		<-req.Context().Done()
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := Session{}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	res := structType{}
+	url := "http://" + srv.Listener.Addr().String()
+	_, err := s.GetContext(ctx, url, nil, &res, nil)
+	assert.Equal(t, context.Canceled, err)
+}
+
 //
 // TODO: Response Tests
 //
@@ -332,6 +383,13 @@ func TestUnmarshal(t *testing.T) {}
 
 func TestUnmarshalFail(t *testing.T) {}
 
+func handleBlock(w http.ResponseWriter, req *http.Request) {
+	select {
+	case <-req.Context().Done():
+	case <-time.After(5 * time.Second):
+	}
+}
+
 func handleEmptyOK(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,420 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements the signing of requests, with AWS Signature Version 4
+or a generic HMAC scheme.
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Signer signs every request sent, including retries.  It runs after the
+// Authenticator and any Middleware, just before the request is sent, so it
+// sees its final method, URL, headers and body.  Redirected requests are not
+// signed.
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// signed returns a Handler signing requests with signer before passing them
+// to next.
+func signed(signer Signer, next Handler) Handler {
+	return func(req *http.Request) (*Response, error) {
+		if err := signer.Sign(req); err != nil {
+			return nil, err
+		}
+		return next(req)
+	}
+}
+
+// signer returns the Signer for r, if any.
+func (s *Session) signer(r *Request) Signer {
+	if r.Signer != nil {
+		return r.Signer
+	}
+	return s.Signer
+}
+
+// emptyHash is the SHA-256 hash of an empty body.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// bodyHash returns the hex-encoded SHA-256 hash of the body of req.  It
+// reports false if the body can only be read once.
+func bodyHash(req *http.Request) (hash string, ok bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return emptyHash, true, nil
+	}
+	if req.GetBody == nil {
+		return "", false, nil
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return "", false, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, rc); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), true, nil
+}
+
+//
+// AWS Signature Version 4
+//
+
+// SigV4 signs requests with AWS Signature Version 4, in the Authorization
+// header.  Host, Content-Type, Content-MD5 and X-Amz-* headers are signed.
+type SigV4 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Optional, for temporary credentials
+	Region          string // e.g. "us-east-1"
+	Service         string // e.g. "s3"
+
+	// UnsignedPayload can be set to true to leave the body out of the
+	// signature, as S3 allows.  Bodies that cannot be read twice are never
+	// signed.
+	UnsignedPayload bool
+
+	now func() time.Time
+}
+
+// Sign adds the X-Amz-Date and Authorization headers to req.
+func (v *SigV4) Sign(req *http.Request) error {
+	now := time.Now
+	if v.now != nil {
+		now = v.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if v.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", v.SessionToken)
+	}
+	payload := "UNSIGNED-PAYLOAD"
+	if !v.UnsignedPayload {
+		hash, ok, err := bodyHash(req)
+		if err != nil {
+			return err
+		}
+		if ok {
+			payload = hash
+		}
+	}
+	if v.Service == "s3" || payload == "UNSIGNED-PAYLOAD" {
+		req.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+	//
+	// Canonical request.  Paths are encoded again, except for S3.
+	//
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if v.Service != "s3" {
+		path = uriEncode(path, false)
+	}
+	names := signedHeaders(req, func(name string) bool {
+		return name == "host" || name == "content-type" || name == "content-md5" || strings.HasPrefix(name, "x-amz-")
+	})
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req),
+		canonicalHeaders(req, names),
+		strings.Join(names, ";"),
+		payload,
+	}, "\n")
+	//
+	// Signature
+	//
+	date := t.Format("20060102")
+	scope := strings.Join([]string{date, v.Region, v.Service, "aws4_request"}, "/")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex(canonical)}, "\n")
+	key := []byte("AWS4" + v.SecretAccessKey)
+	for _, part := range []string{date, v.Region, v.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		v.AccessKeyID, scope, strings.Join(names, ";"), signature))
+	return nil
+}
+
+//
+// HMAC
+//
+
+// HMACSigner signs requests with an HMAC-SHA256 of a canonical request made
+// of the method, path, sorted query, signed headers, their names and the
+// SHA-256 hash of the body, separated by newlines as in AWS Signature
+// Version 4:
+//
+//	POST
+//	/items
+//	page=1
+//	date:Mon, 02 Jan 2006 15:04:05 GMT
+//	host:example.com
+//
+//	date;host
+//	e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+//
+// The signature is sent in the Authorization header:
+//
+//	HMAC-SHA256 KeyId=key, SignedHeaders=date;host, Signature=<base64>
+//
+// The Date and Host headers are always signed; a Date header is added if
+// the request has none.
+type HMACSigner struct {
+	KeyID   string
+	Secret  []byte
+	Headers []string // Additional headers to sign
+
+	// MaxSkew is how far the Date of requests may be from the current
+	// time for Verify to accept them.  Defaults to 5 minutes.
+	MaxSkew time.Duration
+
+	now func() time.Time
+}
+
+// Sign adds the Authorization header, and the Date header if missing, to
+// req.  Bodies that cannot be read twice cannot be signed.
+func (h *HMACSigner) Sign(req *http.Request) error {
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", h.time().UTC().Format(http.TimeFormat))
+	}
+	names := h.signedHeaders(req)
+	signature, err := h.signature(req, names)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("HMAC-SHA256 KeyId=%s, SignedHeaders=%s, Signature=%s",
+		h.KeyID, strings.Join(names, ";"), signature))
+	return nil
+}
+
+// Verify checks the signature of a request received by a server.  The body
+// of req is read, and replaced with a copy.
+func (h *HMACSigner) Verify(req *http.Request) error {
+	var keyID, headers, signature string
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "HMAC-SHA256 ") {
+		return errors.New("napping: request is not signed")
+	}
+	for _, p := range strings.Split(strings.TrimPrefix(auth, "HMAC-SHA256 "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "KeyId":
+			keyID = kv[1]
+		case "SignedHeaders":
+			headers = kv[1]
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	if keyID != h.KeyID {
+		return fmt.Errorf("napping: unknown signing key %q", keyID)
+	}
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("napping: bad signature date: %v", err)
+	}
+	skew := h.MaxSkew
+	if skew == 0 {
+		skew = 5 * time.Minute
+	}
+	if d := h.time().Sub(date); d > skew || d < -skew {
+		return errors.New("napping: signature date is too far from the current time")
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	names := strings.Split(headers, ";")
+	if !contains(names, "date") || !contains(names, "host") {
+		return errors.New("napping: Date and Host headers must be signed")
+	}
+	expected, err := h.signature(req, names)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("napping: bad signature")
+	}
+	return nil
+}
+
+// time returns the current time.
+func (h *HMACSigner) time() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// signedHeaders returns the lower-cased names of the headers of req that
+// are signed, in order.
+func (h *HMACSigner) signedHeaders(req *http.Request) []string {
+	return signedHeaders(req, func(name string) bool {
+		if name == "date" || name == "host" {
+			return true
+		}
+		for _, n := range h.Headers {
+			if strings.EqualFold(n, name) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// signature returns the base64-encoded signature of req, signing the named
+// headers.
+func (h *HMACSigner) signature(req *http.Request, names []string) (string, error) {
+	hash, ok, err := bodyHash(req)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errors.New("napping: cannot sign a body that cannot be read twice")
+	}
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req),
+		canonicalHeaders(req, names),
+		strings.Join(names, ";"),
+		hash,
+	}, "\n")
+	return base64.StdEncoding.EncodeToString(hmacSHA256(h.Secret, canonical)), nil
+}
+
+//
+// Canonicalization
+//
+
+// signedHeaders returns the lower-cased names of the headers of req, and
+// host, that are selected by sign, in order.
+func signedHeaders(req *http.Request, sign func(name string) bool) []string {
+	names := []string{}
+	if sign("host") {
+		names = append(names, "host")
+	}
+	for k := range req.Header {
+		name := strings.ToLower(k)
+		if name != "host" && sign(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// canonicalHeaders returns the named headers of req, one "name:value" line
+// each, with their values trimmed and joined by commas.
+func canonicalHeaders(req *http.Request, names []string) string {
+	lines := make([]string, len(names))
+	for i, name := range names {
+		var values []string
+		if name == "host" {
+			host := req.Host
+			if host == "" {
+				host = req.URL.Host
+			}
+			values = []string{host}
+		} else {
+			values = req.Header.Values(name)
+		}
+		for j, v := range values {
+			values[j] = strings.Join(strings.Fields(v), " ")
+		}
+		lines[i] = name + ":" + strings.Join(values, ",")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// canonicalQuery returns the query of req, encoded and sorted by name then
+// value.
+func canonicalQuery(req *http.Request) string {
+	var pairs []string
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes every byte of s but unreserved characters, and
+// slashes unless encodeSlash is true.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sha256Hex returns the hex-encoded SHA-256 hash of s.
+func sha256Hex(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of s with key.
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// contains reports whether ss contains s.
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The credentials of the AWS Signature Version 4 test suite
+var sigV4TestSigner = SigV4{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	Region:          "us-east-1",
+	Service:         "service",
+	now: func() time.Time {
+		return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	},
+}
+
+func TestSigV4(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		signed      string
+		signature   string
+	}{
+		{"get-vanilla", "GET", "/", "", "", "host;x-amz-date",
+			"5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "GET", "/?Param2=value2&Param1=value1", "", "", "host;x-amz-date",
+			"b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"post-vanilla", "POST", "/", "", "", "host;x-amz-date",
+			"5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-x-www-form-urlencoded", "POST", "/", "application/x-www-form-urlencoded", "Param1=value1", "content-type;host;x-amz-date",
+			"ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+	}
+	for _, test := range tests {
+		var body io.Reader
+		if test.body != "" {
+			body = strings.NewReader(test.body)
+		}
+		req, err := http.NewRequest(test.method, "https://example.amazonaws.com"+test.url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		signer := sigV4TestSigner
+		if err = signer.Sign(req); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"), test.name)
+		assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders="+test.signed+", Signature="+test.signature, req.Header.Get("Authorization"), test.name)
+	}
+}
+
+func TestSigV4Session(t *testing.T) {
+	var auth, sha string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		sha = req.Header.Get("X-Amz-Content-Sha256")
+	}))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	signer := sigV4TestSigner
+	signer.Service = "s3"
+	s := Session{Signer: &signer}
+	_, err := s.Put(u+"/bucket/a%20key", &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, auth, "/us-east-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=")
+	assert.Equal(t, sha256Hex(`{"Foo":111,"Bar":"foo"}`), sha)
+	//
+	// Bodies that can only be read once are not signed
+	//
+	r := Request{
+		Url:        u,
+		Method:     "PUT",
+		Payload:    struct{ io.Reader }{strings.NewReader("streamed")},
+		RawPayload: true,
+	}
+	_, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "UNSIGNED-PAYLOAD", sha)
+}
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHMACSigner(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signer := &HMACSigner{
+		KeyID:   "key1",
+		Secret:  []byte("hunter2"),
+		Headers: []string{"Content-Type"},
+		now:     func() time.Time { return now },
+	}
+	req, err := http.NewRequest("POST", "http://example.com/items?page=1&a=b%20c", strings.NewReader(`{"Foo":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err = signer.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Sun, 30 Aug 2015 12:36:00 GMT", req.Header.Get("Date"))
+	// Computed independently with openssl dgst -sha256 -hmac hunter2 -binary | base64
+	assert.Equal(t, "HMAC-SHA256 KeyId=key1, SignedHeaders=content-type;date;host, "+
+		"Signature=7mHWPvCLLyNXr/18KnDJ6xZd2se/mXEEEL+f1fcBrf4=", req.Header.Get("Authorization"))
+	//
+	// Through a Session, verified by the server
+	//
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if verifyErr = signer.Verify(req); verifyErr != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	u := "http://" + srv.Listener.Addr().String()
+	s := Session{Signer: signer}
+	resp, err := s.Post(u+"/items", &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, verifyErr)
+	assert.Equal(t, 200, resp.Status())
+	//
+	// Changes made by middleware are signed
+	//
+	s.Middleware = []Middleware{func(next Handler) Handler {
+		return func(req *http.Request) (*Response, error) {
+			q := req.URL.Query()
+			q.Set("tenant", "42")
+			req.URL.RawQuery = q.Encode()
+			req.Header.Set("Content-Type", "text/plain")
+			return next(req)
+		}
+	}}
+	resp, err = s.Post(u+"/items", &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, verifyErr)
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, "tenant=42", resp.HttpResponse().Request.URL.RawQuery)
+	//
+	// Tampering after signing
+	//
+	s.Middleware = nil
+	s.Client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req.Header.Set("Content-Type", "text/plain")
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	resp, err = s.Post(u+"/items", &fooStruct, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, resp.Status())
+	assert.EqualError(t, verifyErr, "napping: bad signature")
+	now = now.Add(time.Hour)
+	assert.EqualError(t, signer.Verify(req), "napping: signature date is too far from the current time")
+}
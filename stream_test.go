@@ -0,0 +1,134 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// handleStream writes n JSON-encoded structTypes, flushing after each one, and
+// then waits for release to be closed before finishing the response.
+func handleStream(n int, release chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		for i := 0; i < n; i++ {
+			enc.Encode(structType{Foo: i, Bar: fmt.Sprint("item ", i)})
+			w.(http.Flusher).Flush()
+		}
+		if release != nil {
+			<-release
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(handleStream(3, release))
+	defer srv.Close()
+	res := structType{}
+	r := Request{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: "GET",
+		Stream: true,
+		Result: &res,
+	}
+	//
+	// Send returns while the server is still holding the response open
+	//
+	resp, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body().Close()
+	assert.Equal(t, 200, resp.Status())
+	assert.Equal(t, structType{0, "item 0"}, res)
+	next := structType{}
+	assert.Nil(t, resp.Decode(&next))
+	assert.Equal(t, structType{1, "item 1"}, next)
+	assert.Nil(t, resp.Decode(&next))
+	assert.Equal(t, structType{2, "item 2"}, next)
+	close(release)
+	assert.Equal(t, io.EOF, resp.Decode(&next))
+}
+
+func TestStreamBody(t *testing.T) {
+	srv := httptest.NewServer(handleStream(2, nil))
+	defer srv.Close()
+	r := Request{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: "GET",
+		Stream: true,
+	}
+	resp, err := Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := resp.Body()
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "{\"Foo\":0,\"Bar\":\"item 0\"}\n{\"Foo\":1,\"Bar\":\"item 1\"}\n", string(b))
+	assert.Equal(t, "", resp.RawText())
+}
+
+func TestStreamBufferedDecoder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/x-upper")
+		w.Write([]byte("NAPPING"))
+	}))
+	defer srv.Close()
+	var res string
+	s := Session{Codecs: []Codec{upperCodec{}}}
+	r := Request{
+		Url:    "http://" + srv.Listener.Addr().String(),
+		Method: "GET",
+		Stream: true,
+		Result: &res,
+	}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body().Close()
+	assert.Equal(t, "NAPPING", res)
+	assert.Equal(t, io.EOF, resp.Decode(&res))
+}
+
+func TestStreamRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(flakyHandler(1, http.StatusServiceUnavailable, &calls))
+	defer srv.Close()
+	policy := fastRetry
+	s := Session{Retry: &policy}
+	r := Request{
+		Url:     "http://" + srv.Listener.Addr().String(),
+		Method:  "PUT",
+		Payload: &fooStruct,
+		Stream:  true,
+	}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body().Close()
+	assert.Equal(t, 2, resp.Attempts())
+	res := structType{}
+	assert.Nil(t, resp.Decode(&res))
+	assert.Equal(t, fooStruct, res)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
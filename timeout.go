@@ -0,0 +1,218 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module implements timeouts for each phase of a request.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The phases of a request that can time out.
+const (
+	PhaseDial           = "dial"
+	PhaseTLSHandshake   = "TLS handshake"
+	PhaseResponseHeader = "response header"
+	PhaseBodyRead       = "body read"
+	PhaseTotal          = "total"
+)
+
+// Timeouts limits the duration of each phase of a request; zero means no
+// limit.  ResponseHeader runs from the end of writing the request to the
+// response headers, BodyRead from the response headers to the end of the
+// body, and Total covers every attempt, the delays between them, and
+// reading the body, even if it is streamed.
+//
+// The Timeouts of a Request override the non-zero fields of the Session's.
+// Dial, TLSHandshake and ResponseHeader are not applied to the Transport of
+// a Client set by the caller.
+type Timeouts struct {
+	Dial           time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	BodyRead       time.Duration
+	Total          time.Duration
+}
+
+// TimeoutError is returned when a phase of a request times out.
+type TimeoutError struct {
+	Phase    string // One of the Phase constants
+	Duration time.Duration
+	Err      error // Reported by net/http, if any
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("napping: %s timeout of %s exceeded", e.Phase, e.Duration)
+}
+
+// Timeout returns true, as for a net.Error.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// timeouts returns the timeouts of r.
+func (s *Session) timeouts(r *Request) Timeouts {
+	var t Timeouts
+	if s.Timeouts != nil {
+		t = *s.Timeouts
+	}
+	if o := r.Timeouts; o != nil {
+		for _, f := range []struct{ to, from *time.Duration }{
+			{&t.Dial, &o.Dial},
+			{&t.TLSHandshake, &o.TLSHandshake},
+			{&t.ResponseHeader, &o.ResponseHeader},
+			{&t.BodyRead, &o.BodyRead},
+			{&t.Total, &o.Total},
+		} {
+			if *f.from != 0 {
+				*f.to = *f.from
+			}
+		}
+	}
+	return t
+}
+
+// transport returns the timeouts applied by a transport.
+func (t Timeouts) transport() Timeouts {
+	return Timeouts{Dial: t.Dial, TLSHandshake: t.TLSHandshake, ResponseHeader: t.ResponseHeader}
+}
+
+// apply sets the timeouts of transport.
+func (t Timeouts) apply(transport *http.Transport) {
+	if t.Dial > 0 {
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dctx, cancel := context.WithTimeout(ctx, t.Dial)
+			defer cancel()
+			conn, err := dial(dctx, network, addr)
+			if err != nil && ctx.Err() == nil && dctx.Err() == context.DeadlineExceeded {
+				err = &TimeoutError{Phase: PhaseDial, Duration: t.Dial, Err: err}
+			}
+			return conn, err
+		}
+	}
+	if t.TLSHandshake > 0 {
+		transport.TLSHandshakeTimeout = t.TLSHandshake
+	}
+	if t.ResponseHeader > 0 {
+		transport.ResponseHeaderTimeout = t.ResponseHeader
+	}
+}
+
+// wrap returns err as a *TimeoutError if it reports the timeout of a
+// phase.
+func (t Timeouts) wrap(err error) error {
+	var te *TimeoutError
+	if errors.As(err, &te) {
+		return te
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		return err
+	}
+	// net/http has no exported errors for these
+	switch {
+	case t.TLSHandshake > 0 && strings.Contains(err.Error(), "TLS handshake timeout"):
+		return &TimeoutError{Phase: PhaseTLSHandshake, Duration: t.TLSHandshake, Err: err}
+	case t.ResponseHeader > 0 && strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return &TimeoutError{Phase: PhaseResponseHeader, Duration: t.ResponseHeader, Err: err}
+	}
+	return err
+}
+
+// cancellable returns a copy of req which is cancelled by the function
+// returned.
+func cancellable(req *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(req.Context())
+	return req.WithContext(ctx), cancel
+}
+
+// timedBody is a response body that must be read within a timeout.  When
+// it expires, the request is cancelled, and reads fail with a
+// *TimeoutError.  The request is also cancelled when the body is closed.
+type timedBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func newTimedBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *timedBody {
+	b := &timedBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(timeout, func() {
+			b.mu.Lock()
+			b.expired = true
+			b.mu.Unlock()
+			cancel()
+		})
+	}
+	return b
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		if b.expired {
+			err = &TimeoutError{Phase: PhaseBodyRead, Duration: b.timeout, Err: err}
+		}
+		b.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// totalBody is a streamed response body bound by the Total timeout, which
+// is stopped when the body is closed.
+type totalBody struct {
+	io.ReadCloser
+	ctx      context.Context // Expiring with the Total timeout
+	duration time.Duration
+	stop     context.CancelFunc
+}
+
+func (b totalBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.ctx.Err() == context.DeadlineExceeded {
+		err = &TimeoutError{Phase: PhaseTotal, Duration: b.duration, Err: err}
+	}
+	return n, err
+}
+
+func (b totalBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.stop()
+	return err
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertTimeout asserts that err is a *TimeoutError of phase.
+func assertTimeout(t *testing.T, err error, phase string, d time.Duration) {
+	var te *TimeoutError
+	if assert.True(t, errors.As(err, &te), "%v", err) {
+		assert.Equal(t, phase, te.Phase)
+		assert.Equal(t, d, te.Duration)
+		assert.Equal(t, "napping: "+phase+" timeout of "+d.String()+" exceeded", err.Error())
+	}
+}
+
+// slowServer sends its response headers after headerDelay, then half its
+// body, and the rest after bodyDelay.
+func slowServer(headerDelay, bodyDelay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(headerDelay)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Foo": 111, `))
+		w.(http.Flusher).Flush()
+		time.Sleep(bodyDelay)
+		w.Write([]byte(`"Bar": "foo"}`))
+	}))
+}
+
+func TestTimeoutsMerge(t *testing.T) {
+	s := Session{Timeouts: &Timeouts{Dial: time.Second, Total: time.Minute}}
+	r := Request{Timeouts: &Timeouts{Total: time.Second, BodyRead: time.Second}}
+	assert.Equal(t, Timeouts{Dial: time.Second, BodyRead: time.Second, Total: time.Second}, s.timeouts(&r))
+	assert.Equal(t, *s.Timeouts, s.timeouts(&Request{}))
+	assert.Equal(t, Timeouts{}, (&Session{}).timeouts(&Request{}))
+}
+
+func TestDialTimeout(t *testing.T) {
+	blackhole := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	s := Session{Timeouts: &Timeouts{Dial: 50 * time.Millisecond}}
+	_, err := s.Send(&Request{Url: "http://example.test/", Method: "GET", Transport: blackhole})
+	assertTimeout(t, err, PhaseDial, 50*time.Millisecond)
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Accept connections, and never answer
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				<-done
+				c.Close()
+			}()
+		}
+	}()
+	s := Session{Timeouts: &Timeouts{TLSHandshake: 50 * time.Millisecond}}
+	_, err = s.Get("https://"+l.Addr().String(), nil, nil, nil)
+	assertTimeout(t, err, PhaseTLSHandshake, 50*time.Millisecond)
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	srv := slowServer(200*time.Millisecond, 0)
+	defer srv.Close()
+	s := Session{Timeouts: &Timeouts{ResponseHeader: 50 * time.Millisecond}}
+	_, err := s.Get(srv.URL, nil, nil, nil)
+	assertTimeout(t, err, PhaseResponseHeader, 50*time.Millisecond)
+	//
+	// Overridden per request
+	//
+	res := structType{}
+	r := Request{Url: srv.URL, Method: "GET", Result: &res, Timeouts: &Timeouts{ResponseHeader: time.Second}}
+	_, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fooStruct, res)
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	srv := slowServer(0, 200*time.Millisecond)
+	defer srv.Close()
+	s := Session{Timeouts: &Timeouts{BodyRead: 50 * time.Millisecond}}
+	_, err := s.Get(srv.URL, nil, nil, nil)
+	assertTimeout(t, err, PhaseBodyRead, 50*time.Millisecond)
+	//
+	// Streamed
+	//
+	r := Request{Url: srv.URL, Method: "GET", Stream: true}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body().Close()
+	_, err = ioutil.ReadAll(resp.Body())
+	assertTimeout(t, err, PhaseBodyRead, 50*time.Millisecond)
+	//
+	// In time
+	//
+	s.Timeouts.BodyRead = time.Second
+	res := structType{}
+	_, err = s.Get(srv.URL, nil, &res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fooStruct, res)
+}
+
+func TestTotalTimeout(t *testing.T) {
+	srv := slowServer(100*time.Millisecond, 100*time.Millisecond)
+	defer srv.Close()
+	s := Session{Timeouts: &Timeouts{Total: 150 * time.Millisecond}}
+	_, err := s.Get(srv.URL, nil, nil, nil)
+	assertTimeout(t, err, PhaseTotal, 150*time.Millisecond)
+	//
+	// The body of a streamed response is covered
+	//
+	r := Request{Url: srv.URL, Method: "GET", Stream: true}
+	resp, err := s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(resp.Body())
+	assertTimeout(t, err, PhaseTotal, 150*time.Millisecond)
+	resp.Body().Close()
+	//
+	// The caller's context still wins
+	//
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = s.GetContext(ctx, srv.URL, nil, nil, nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	//
+	// In time
+	//
+	res := structType{}
+	r = Request{Url: srv.URL, Method: "GET", Result: &res, Timeouts: &Timeouts{Total: time.Second}}
+	_, err = s.Send(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fooStruct, res)
+}
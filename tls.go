@@ -0,0 +1,208 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+/*
+This module builds the TLS configuration of a Session.
+*/
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS configures the TLS connections of a Session.  The client certificate
+// is read from CertFile and KeyFile, or else from CertPEM and KeyPEM; files
+// are read again whenever they change, so certificates can be renewed
+// without restarting the process.  It is safe for concurrent use, and must
+// not be copied after first use.
+type TLS struct {
+	CertFile string
+	KeyFile  string
+	CertPEM  []byte
+	KeyPEM   []byte
+
+	// CAFiles and CAPEM hold root CAs trusted in addition to those of the
+	// system.
+	CAFiles []string
+	CAPEM   []byte
+
+	// MinVersion is the minimum TLS version accepted, such as
+	// tls.VersionTLS13; if zero, the default of crypto/tls is used.
+	MinVersion uint16
+
+	// Pins, if set, lists the SHA-256 hashes of the public keys (SPKI)
+	// trusted in the certificate chain of servers, base64 encoded with an
+	// optional "sha256/" prefix.  A chain containing none of them fails
+	// with a *PinError.
+	Pins []string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // Of CertFile and KeyFile, when cert was loaded
+}
+
+// PinError is returned when no public key of a server's certificate chain
+// is pinned.
+type PinError struct {
+	Host string   // Empty if the server was addressed by IP
+	Pins []string // Those of the chain
+}
+
+func (e *PinError) Error() string {
+	host := e.Host
+	if host == "" {
+		host = "the server"
+	}
+	return fmt.Sprintf("napping: certificate of %s does not match any pinned key (got %s)", host, strings.Join(e.Pins, ", "))
+}
+
+// SPKIPin returns the pin of the public key of cert, in the format of
+// TLS.Pins.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Config returns a new tls.Config applying the settings of t.
+func (t *TLS) Config() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if err := t.apply(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// apply applies the settings of t to cfg.
+func (t *TLS) apply(cfg *tls.Config) error {
+	if t.MinVersion != 0 {
+		cfg.MinVersion = t.MinVersion
+	}
+	//
+	// Root CAs
+	//
+	if len(t.CAFiles) > 0 || len(t.CAPEM) > 0 {
+		pool := cfg.RootCAs
+		if pool == nil {
+			var err error
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+		for _, name := range t.CAFiles {
+			b, err := ioutil.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return fmt.Errorf("napping: no certificate found in %s", name)
+			}
+		}
+		if len(t.CAPEM) > 0 && !pool.AppendCertsFromPEM(t.CAPEM) {
+			return fmt.Errorf("napping: no certificate found in CAPEM")
+		}
+		cfg.RootCAs = pool
+	}
+	//
+	// Client certificate, checked now so that errors are reported early
+	//
+	if t.CertFile != "" || len(t.CertPEM) > 0 {
+		if _, err := t.certificate(); err != nil {
+			return err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return t.certificate()
+		}
+	}
+	//
+	// Pinning, after the usual verification
+	//
+	if len(t.Pins) > 0 {
+		pins := map[string]bool{}
+		for _, p := range t.Pins {
+			pins["sha256/"+strings.TrimPrefix(p, "sha256/")] = true
+		}
+		verify := cfg.VerifyConnection
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			chain := cs.PeerCertificates
+			if len(cs.VerifiedChains) > 0 {
+				chain = cs.VerifiedChains[0]
+			}
+			e := &PinError{Host: cs.ServerName}
+			for _, cert := range chain {
+				pin := SPKIPin(cert)
+				if pins[pin] {
+					return nil
+				}
+				e.Pins = append(e.Pins, pin)
+			}
+			return e
+		}
+	}
+	return nil
+}
+
+// certificate returns the client certificate, loading it if it has never
+// been loaded or if its files have changed.
+func (t *TLS) certificate() (*tls.Certificate, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.CertFile == "" {
+		if t.cert == nil {
+			cert, err := tls.X509KeyPair(t.CertPEM, t.KeyPEM)
+			if err != nil {
+				return nil, err
+			}
+			t.cert = &cert
+		}
+		return t.cert, nil
+	}
+	var modTime time.Time
+	for _, name := range []string{t.CertFile, t.KeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	if t.cert == nil || !modTime.Equal(t.modTime) {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		t.cert, t.modTime = &cert, modTime
+	}
+	return t.cert, nil
+}
+
+// Reload loads the client certificate again, e.g. after CertPEM and KeyPEM
+// have been changed.  Connections already established keep the previous
+// certificate.
+func (t *TLS) Reload() error {
+	t.mu.Lock()
+	t.cert = nil
+	t.mu.Unlock()
+	if t.CertFile == "" && len(t.CertPEM) == 0 {
+		return nil
+	}
+	_, err := t.certificate()
+	return err
+}
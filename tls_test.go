@@ -0,0 +1,199 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released
+// under the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for
+// details.  Resist intellectual serfdom - the ownership of ideas is akin to
+// slavery.
+
+package napping
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a certificate and its key, issued by a test CA.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate for name, signed by ca or self-signed.
+func newTestCert(t *testing.T, name string, ca *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// newMTLSServer returns a server with a certificate issued by ca, which
+// answers with the common name of the client certificate, if any.
+func newMTLSServer(t *testing.T, ca *testCert) *httptest.Server {
+	cert := newTestCert(t, "server", ca)
+	pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	srv := newMTLSServer(t, ca)
+	defer srv.Close()
+	client := newTestCert(t, "jtkirk", ca)
+	get := func(s *Session) (string, error) {
+		resp, err := s.Get(srv.URL, nil, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.RawText(), nil
+	}
+	//
+	// Extra root CA, and client certificate from PEM bytes
+	//
+	body, err := get(&Session{TLS: &TLS{CAPEM: ca.certPEM}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", body)
+	body, err = get(&Session{TLS: &TLS{CAPEM: ca.certPEM, CertPEM: client.certPEM, KeyPEM: client.keyPEM}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "jtkirk", body)
+	//
+	// Configuration errors are reported
+	//
+	_, err = get(&Session{TLS: &TLS{CAPEM: []byte("junk")}})
+	assert.EqualError(t, err, "napping: no certificate found in CAPEM")
+	_, err = get(&Session{TLS: &TLS{CertPEM: client.certPEM, KeyPEM: ca.keyPEM}})
+	assert.NotNil(t, err)
+	//
+	// Minimum version
+	//
+	srv.TLS.MaxVersion = tls.VersionTLS12
+	_, err = get(&Session{TLS: &TLS{CAPEM: ca.certPEM, MinVersion: tls.VersionTLS13}})
+	assert.NotNil(t, err)
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	srv := newMTLSServer(t, ca)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "napping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(c *testCert, modTime time.Time) {
+		for name, b := range map[string][]byte{"ca.pem": ca.certPEM, "cert.pem": c.certPEM, "key.pem": c.keyPEM} {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, b, 0600); err != nil {
+				t.Fatal(err)
+			}
+			os.Chtimes(path, modTime, modTime)
+		}
+	}
+	now := time.Now()
+	write(newTestCert(t, "jtkirk", ca), now)
+	s := Session{TLS: &TLS{
+		CAFiles:  []string{filepath.Join(dir, "ca.pem")},
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+	}}
+	get := func() string {
+		resp, err := s.Get(srv.URL, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.RawText()
+	}
+	assert.Equal(t, "jtkirk", get())
+	//
+	// The renewed certificate is used by new connections
+	//
+	write(newTestCert(t, "spock", ca), now.Add(time.Minute))
+	assert.Equal(t, "jtkirk", get())
+	srv.CloseClientConnections()
+	assert.Equal(t, "spock", get())
+}
+
+func TestTLSPins(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	srv := newMTLSServer(t, ca)
+	defer srv.Close()
+	other := newTestCert(t, "other", nil)
+	s := Session{TLS: &TLS{CAPEM: ca.certPEM, Pins: []string{SPKIPin(other.cert), SPKIPin(ca.cert)[7:]}}}
+	_, err := s.Get(srv.URL, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = Session{TLS: &TLS{CAPEM: ca.certPEM, Pins: []string{SPKIPin(other.cert)}}}
+	_, err = s.Get(srv.URL, nil, nil, nil)
+	var pinErr *PinError
+	if assert.True(t, errors.As(err, &pinErr)) {
+		assert.Contains(t, err.Error(), "napping: certificate of the server does not match any pinned key (got sha256/")
+		assert.Len(t, pinErr.Pins, 2)
+		assert.Equal(t, SPKIPin(ca.cert), pinErr.Pins[1])
+	}
+}